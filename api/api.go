@@ -0,0 +1,184 @@
+// Package api provides a small HTTP/JSON interface to watch and drive the scene controller.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/mgutz/logxi/v1"
+	"github.com/tolleiv/nuimo-fhem/metrics"
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+	"github.com/tolleiv/nuimo-fhem/scenes"
+)
+
+var logger = log.New("api")
+
+// Controller is the part of the scene controller used by the API
+type Controller interface {
+	Status() scenes.Status
+	SwitchScene(name string) error
+	Inject(event nuimo.Event) error
+	Lock(locked bool)
+}
+
+// Connector reports the state of a connection, e.g. to the FHEM server
+type Connector interface {
+	Connected() bool
+}
+
+type Server struct {
+	Address    string
+	Controller Controller
+	Fhem       Connector
+
+	mu     sync.Mutex
+	server *http.Server
+}
+
+type status struct {
+	scenes.Status
+	FhemConnected bool `json:"fhem_connected"`
+}
+
+type sceneRequest struct {
+	Scene string `json:"scene"`
+}
+
+type lockRequest struct {
+	Locked bool `json:"locked"`
+}
+
+type eventRequest struct {
+	Key   string `json:"key"`
+	Value int64  `json:"value"`
+}
+
+// Handler returns the API endpoints
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.status)
+	mux.HandleFunc("/scenes", s.scenes)
+	mux.HandleFunc("/events", s.events)
+	mux.HandleFunc("/commands", s.commands)
+	mux.HandleFunc("/lock", s.lock)
+	mux.Handle("/metrics", metrics.Handler())
+	return mux
+}
+
+// ListenAndServe serves the API endpoints on the configured address until Shutdown is called
+func (s *Server) ListenAndServe() error {
+	logger.Info("HTTP API listening", "address", s.Address)
+	if err := s.httpServer().ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops the server and waits for the running requests until the context is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer().Shutdown(ctx)
+}
+
+func (s *Server) httpServer() *http.Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.server == nil {
+		s.server = &http.Server{Addr: s.Address, Handler: s.Handler()}
+	}
+	return s.server
+}
+
+// status serves GET /status with the complete controller state
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, status{Status: s.Controller.Status(), FhemConnected: s.Fhem.Connected()})
+}
+
+// scenes serves GET /scenes with the current and the known scenes, a POST with
+// {"scene": "name"} switches to the given scene
+func (s *Server) scenes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		var req sceneRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.Controller.SwitchScene(req.Scene); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	st := s.Controller.Status()
+	writeJSON(w, map[string]interface{}{"scene": st.Scene, "scenes": st.Scenes})
+}
+
+// events serves GET /events with the recent events, a POST with {"key": "swipe_up", "value": 0}
+// injects a synthetic event
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, s.Controller.Status().Events)
+	case "POST":
+		var req eventRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Key == "" {
+			http.Error(w, "Missing event key", http.StatusBadRequest)
+			return
+		}
+		if err := s.Controller.Inject(nuimo.Event{Key: req.Key, Value: req.Value}); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// commands serves GET /commands with the recently dispatched commands
+func (s *Server) commands(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, s.Controller.Status().Commands)
+}
+
+// lock serves GET /lock with the state of the child lock, a POST with {"locked": true}
+// locks or unlocks the Nuimo
+func (s *Server) lock(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		var req lockRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.Controller.Lock(req.Locked)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, lockRequest{Locked: s.Controller.Status().Locked})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error("Unable to write response", "err", err)
+	}
+}
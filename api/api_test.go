@@ -0,0 +1,187 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+	"github.com/tolleiv/nuimo-fhem/scenes"
+)
+
+// controller is a stand-in for the scene controller
+type controller struct {
+	mu       sync.Mutex
+	scene    string
+	locked   bool
+	injected []nuimo.Event
+	full     bool
+}
+
+func (c *controller) Status() scenes.Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return scenes.Status{Scene: c.scene, Scenes: []string{"light", "music"}, Locked: c.locked, Battery: 80}
+}
+
+func (c *controller) SwitchScene(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if name != "light" && name != "music" {
+		return fmt.Errorf("Unknown scene %s", name)
+	}
+	c.scene = name
+	return nil
+}
+
+func (c *controller) Inject(event nuimo.Event) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.full {
+		return errors.New("Too many queued events")
+	}
+	c.injected = append(c.injected, event)
+	return nil
+}
+
+func (c *controller) Lock(locked bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.locked = locked
+}
+
+type connector bool
+
+func (c connector) Connected() bool { return bool(c) }
+
+func newServer() (*Server, *controller) {
+	c := &controller{scene: "light"}
+	return &Server{Controller: c, Fhem: connector(true)}, c
+}
+
+func request(s *Server, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+func TestStatus(t *testing.T) {
+	s, _ := newServer()
+	w := request(s, "GET", "/status", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var st map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st["scene"] != "light" || st["fhem_connected"] != true || st["battery"] != 80.0 {
+		t.Errorf("unexpected status %s", w.Body.String())
+	}
+
+	if w := request(s, "POST", "/status", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected POST to be rejected, got %d", w.Code)
+	}
+}
+
+func TestSwitchScene(t *testing.T) {
+	s, c := newServer()
+	tests := []struct {
+		body  string
+		code  int
+		scene string
+	}{
+		{`{"scene": "music"}`, http.StatusOK, "music"},
+		{`{"scene": "garage"}`, http.StatusNotFound, "music"},
+		{`{"scene":`, http.StatusBadRequest, "music"},
+	}
+	for _, test := range tests {
+		w := request(s, "POST", "/scenes", test.body)
+		if w.Code != test.code {
+			t.Errorf("%s: expected %d, got %d", test.body, test.code, w.Code)
+		}
+		if c.scene != test.scene {
+			t.Errorf("%s: expected scene %s, got %s", test.body, test.scene, c.scene)
+		}
+	}
+
+	w := request(s, "GET", "/scenes", "")
+	if got := strings.TrimSpace(w.Body.String()); got != `{"scene":"music","scenes":["light","music"]}` {
+		t.Errorf("unexpected scenes %s", got)
+	}
+}
+
+func TestInject(t *testing.T) {
+	s, c := newServer()
+	if w := request(s, "POST", "/events", `{"key": "rotate", "value": 20}`); w.Code != http.StatusAccepted {
+		t.Errorf("expected the event to be accepted, got %d", w.Code)
+	}
+	if len(c.injected) != 1 || c.injected[0].Key != "rotate" || c.injected[0].Value != 20 {
+		t.Errorf("unexpected injected events %v", c.injected)
+	}
+	if w := request(s, "POST", "/events", `{"value": 20}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected an event without key to be rejected, got %d", w.Code)
+	}
+	c.full = true
+	if w := request(s, "POST", "/events", `{"key": "press"}`); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected a full queue to be reported, got %d", w.Code)
+	}
+}
+
+func TestLock(t *testing.T) {
+	s, c := newServer()
+	w := request(s, "POST", "/lock", `{"locked": true}`)
+	if got := strings.TrimSpace(w.Body.String()); got != `{"locked":true}` || !c.locked {
+		t.Errorf("expected the controller to be locked, got %s", got)
+	}
+	w = request(s, "GET", "/lock", "")
+	if got := strings.TrimSpace(w.Body.String()); got != `{"locked":true}` {
+		t.Errorf("unexpected lock state %s", got)
+	}
+	if w := request(s, "DELETE", "/lock", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected DELETE to be rejected, got %d", w.Code)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	s, _ := newServer()
+	w := request(s, "GET", "/metrics", "")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("unexpected response %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Body.String(), "# TYPE nuimo_fhem_events_total counter") {
+		t.Errorf("expected the controller metrics, got %s", w.Body.String())
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	s, _ := newServer()
+	s.Address = "127.0.0.1:0"
+	stopped := make(chan error)
+	go func() { stopped <- s.ListenAndServe() }()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("expected no error after Shutdown, got %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected ListenAndServe to return after Shutdown")
+	}
+	if _, pattern := http.DefaultServeMux.Handler(httptest.NewRequest("GET", "/status", nil)); pattern != "" {
+		t.Error("expected the default mux to stay untouched")
+	}
+}
@@ -0,0 +1,27 @@
+package fhem
+
+import (
+	"context"
+
+	"github.com/tolleiv/nuimo-fhem/scenes"
+)
+
+// Client is implemented by everything which is able to handle FHEM commands
+type Client interface {
+	scenes.Handler
+	Connected() bool
+}
+
+// DryRun takes the place of a Fhem client but never sends any command. Every command
+// is answered with a successful "ok" response.
+type DryRun struct{}
+
+// Connected is always false as there is no connection to any FHEM server
+func (d *DryRun) Connected() bool {
+	return false
+}
+
+func (d *DryRun) Handle(ctx context.Context, cmd scenes.Command) (scenes.Result, error) {
+	logger.Debug("Skip command", cmd.Text)
+	return scenes.Result{Output: "ok"}, nil
+}
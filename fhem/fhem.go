@@ -1,63 +1,194 @@
 package fhem
 
 import (
+	"context"
+	"errors"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Cristofori/kmud/telnet"
 	"github.com/mgutz/logxi/v1"
+	"github.com/tolleiv/nuimo-fhem/metrics"
+	"github.com/tolleiv/nuimo-fhem/scenes"
 )
 
 var logger = log.New("fhem")
 
+var errClosed = errors.New("fhem: connection closed")
+
+var (
+	commandDuration  = metrics.NewHistogramVec("nuimo_fhem_fhem_command_duration_seconds", "Time needed to send a command to FHEM.", metrics.DefaultBuckets, "result")
+	commandErrors    = metrics.NewCounterVec("nuimo_fhem_fhem_command_errors_total", "Number of failed FHEM commands.")
+	telnetReconnects = metrics.NewCounterVec("nuimo_fhem_telnet_reconnects_total", "Number of reconnects to the FHEM telnet server.")
+)
+
+// DefaultTimeout limits connecting to FHEM and each command unless the context ends earlier
+const DefaultTimeout = 5 * time.Second
+
+// Fhem sends the commands through the telnet interface of a FHEM server. The connection is
+// established with the first command and re-established once when a command failed.
 type Fhem struct {
-	Address string
+	Address   string
+	Timeout   time.Duration
+	mu        sync.Mutex
+	tn        *telnet.Telnet
+	connected int32
+	// attempted is set after the first connect, later connects are counted as reconnects
+	attempted bool
+
+	// conn is kept apart from tn so that Close can interrupt a command which holds mu
+	connMu sync.Mutex
+	conn   net.Conn
+	closed bool
 }
 
-func (f *Fhem) Commands(commands <-chan string, output chan<- string) error {
-	tn, err := f.connect()
-	if err != nil {
-		return err
+// Connected reports whether the telnet connection to the FHEM server is established
+func (f *Fhem) Connected() bool {
+	return atomic.LoadInt32(&f.connected) == 1
+}
+
+// Handle sends a single command, responses are only read for get and ReadingsVal commands.
+// Empty commands are skipped.
+func (f *Fhem) Handle(ctx context.Context, cmd scenes.Command) (scenes.Result, error) {
+	if strings.TrimSpace(cmd.Text) == "" {
+		return scenes.Result{}, nil
 	}
-	logger.Info("Awaiting commands")
-	for {
-		command, more := <-commands
-		if len(command) == 0 {
-			continue
-		}
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-		logger.Debug("Trigger command", command)
-		if !more {
-			break
-		}
+	logger.Debug("Trigger command", cmd.Text)
+	start := time.Now()
+	result := "error"
+	defer func() { commandDuration.Observe(time.Since(start).Seconds(), result) }()
 
-		data := []byte(command + "\n")
+	ctx, cancel := context.WithTimeout(ctx, f.timeout())
+	defer cancel()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			f.interrupt()
+		case <-done:
+		}
+	}()
 
-		_, err := tn.Write(data)
-		if err != nil {
-			return err
+	if f.tn == nil {
+		if err := f.connect(); err != nil {
+			commandErrors.Inc()
+			return scenes.Result{}, err
 		}
+	}
 
-		if strings.HasPrefix(command, "get ") || strings.HasPrefix(command, "{ReadingsVal") {
-			readBuffer := make([]byte, 1024)
-			n, err := tn.Read(readBuffer)
-			if err != nil {
-				return err
-			}
-			output <- string(readBuffer[:n])
-		} else {
-			output <- "ok"
+	out, err := f.send(ctx, cmd.Text)
+	if err != nil {
+		commandErrors.Inc()
+		logger.Warn("Command failed, reconnecting", "err", err)
+		f.disconnect()
+		if ctx.Err() != nil {
+			return scenes.Result{}, err
+		}
+		if err := f.connect(); err != nil {
+			return scenes.Result{}, err
+		}
+		if out, err = f.send(ctx, cmd.Text); err != nil {
+			commandErrors.Inc()
+			return scenes.Result{}, err
 		}
 	}
+	result = "ok"
+	return scenes.Result{Output: out}, nil
+}
+
+// Close closes the connection to the FHEM server, a command which is still running is
+// interrupted
+func (f *Fhem) Close() error {
+	f.connMu.Lock()
+	f.closed = true
+	f.connMu.Unlock()
+	f.interrupt()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.disconnect()
 	return nil
 }
 
-func (fhem *Fhem) connect() (*telnet.Telnet, error) {
-	conn, err := net.Dial("tcp", fhem.Address)
+func (f *Fhem) send(ctx context.Context, command string) (string, error) {
+	deadline, _ := ctx.Deadline()
+	f.tn.SetDeadline(deadline)
+
+	data := []byte(command + "\n")
+
+	_, err := f.tn.Write(data)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.HasPrefix(command, "get ") || strings.HasPrefix(command, "{ReadingsVal") {
+		readBuffer := make([]byte, 1024)
+		n, err := f.tn.Read(readBuffer)
+		if err != nil {
+			return "", err
+		}
+		return string(readBuffer[:n]), nil
+	}
+	return "ok", nil
+}
+
+func (f *Fhem) connect() error {
+	f.connMu.Lock()
+	closed := f.closed
+	f.connMu.Unlock()
+	if closed {
+		return errClosed
+	}
+
+	reconnect := f.attempted
+	f.attempted = true
+	conn, err := net.DialTimeout("tcp", f.Address, f.timeout())
 	if err != nil {
 		logger.Error("Unable to connect to telnet server", err)
-		return nil, err
+		return err
+	}
+	if reconnect {
+		telnetReconnects.Inc()
+	}
+	f.connMu.Lock()
+	f.conn = conn
+	f.connMu.Unlock()
+	f.tn = telnet.NewTelnet(conn)
+	atomic.StoreInt32(&f.connected, 1)
+	logger.Info("Connected to telnet server", "address", f.Address)
+	return nil
+}
+
+func (f *Fhem) disconnect() {
+	if f.tn != nil {
+		f.tn.Close()
+		f.tn = nil
+	}
+	f.connMu.Lock()
+	f.conn = nil
+	f.connMu.Unlock()
+	atomic.StoreInt32(&f.connected, 0)
+}
+
+// interrupt lets a pending read or write on the connection fail right away
+func (f *Fhem) interrupt() {
+	f.connMu.Lock()
+	defer f.connMu.Unlock()
+	if f.conn != nil {
+		f.conn.SetDeadline(time.Now())
+	}
+}
+
+func (f *Fhem) timeout() time.Duration {
+	if f.Timeout > 0 {
+		return f.Timeout
 	}
-	tn := telnet.NewTelnet(conn)
-	return tn, nil
+	return DefaultTimeout
 }
@@ -0,0 +1,216 @@
+package fhem
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tolleiv/nuimo-fhem/metrics"
+	"github.com/tolleiv/nuimo-fhem/scenes"
+)
+
+// silentServer accepts connections but never answers
+func silentServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	return l
+}
+
+// lineServer accepts connections and passes on every received line
+func lineServer(t *testing.T) (net.Listener, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make(chan string, 16)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				s := bufio.NewScanner(conn)
+				for s.Scan() {
+					lines <- s.Text()
+				}
+			}()
+		}
+	}()
+	return l, lines
+}
+
+func TestHandleSkipsEmptyCommands(t *testing.T) {
+	l, lines := lineServer(t)
+	defer l.Close()
+
+	f := &Fhem{Address: l.Addr().String(), Timeout: time.Second}
+	defer f.Close()
+
+	for _, text := range []string{"", "  ", "set lamp on"} {
+		if _, err := f.Handle(context.Background(), scenes.Command{Handle: "fhem", Text: text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case line := <-lines:
+		if line != "set lamp on" {
+			t.Errorf("expected only the command to be sent, got %q", line)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("command not received")
+	}
+}
+
+func TestHandleTimeout(t *testing.T) {
+	l := silentServer(t)
+	defer l.Close()
+
+	f := &Fhem{Address: l.Addr().String(), Timeout: 100 * time.Millisecond}
+	defer f.Close()
+
+	start := time.Now()
+	if _, err := f.Handle(context.Background(), scenes.Command{Handle: "fhem", Text: "get lamp state"}); err == nil {
+		t.Fatal("expected an error for an unanswered command")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("command took %s, expected the timeout to apply", d)
+	}
+}
+
+func TestHandleCancel(t *testing.T) {
+	l := silentServer(t)
+	defer l.Close()
+
+	f := &Fhem{Address: l.Addr().String(), Timeout: time.Minute}
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		_, err := f.Handle(ctx, scenes.Command{Handle: "fhem", Text: "get lamp state"})
+		errs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("expected an error for a cancelled command")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cancelling the context did not stop the command")
+	}
+}
+
+func TestCloseInterruptsHandle(t *testing.T) {
+	l := silentServer(t)
+	defer l.Close()
+
+	f := &Fhem{Address: l.Addr().String(), Timeout: time.Minute}
+	errs := make(chan error)
+	go func() {
+		_, err := f.Handle(context.Background(), scenes.Command{Handle: "fhem", Text: "get lamp state"})
+		errs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		f.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked on the pending command")
+	}
+	if err := <-errs; err == nil {
+		t.Error("expected an error for the interrupted command")
+	}
+	if f.Connected() {
+		t.Error("expected the client to be disconnected")
+	}
+}
+
+// metricValue returns the served value of the metric series, 0 when it is missing
+func metricValue(t *testing.T, series string) float64 {
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if strings.HasPrefix(line, series+" ") {
+			v, err := strconv.ParseFloat(strings.TrimPrefix(line, series+" "), 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+func TestMetrics(t *testing.T) {
+	l, lines := lineServer(t)
+	address := l.Addr().String()
+	// the server is down for the first command
+	l.Close()
+
+	okCount := `nuimo_fhem_fhem_command_duration_seconds_count{result="ok"}`
+	errorCount := `nuimo_fhem_fhem_command_duration_seconds_count{result="error"}`
+	before := map[string]float64{}
+	for _, series := range []string{okCount, errorCount, "nuimo_fhem_telnet_reconnects_total"} {
+		before[series] = metricValue(t, series)
+	}
+
+	f := &Fhem{Address: address, Timeout: 100 * time.Millisecond}
+	defer f.Close()
+	if _, err := f.Handle(context.Background(), scenes.Command{Handle: "fhem", Text: "set lamp on"}); err == nil {
+		t.Fatal("expected the command to fail without server")
+	}
+
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Skip("unable to listen on the same address again:", err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s := bufio.NewScanner(conn)
+		for s.Scan() {
+			lines <- s.Text()
+		}
+	}()
+	if _, err := f.Handle(context.Background(), scenes.Command{Handle: "fhem", Text: "set lamp on"}); err != nil {
+		t.Fatal(err)
+	}
+	<-lines
+
+	want := map[string]float64{okCount: 1, errorCount: 1, "nuimo_fhem_telnet_reconnects_total": 1}
+	for series, delta := range want {
+		if got := metricValue(t, series) - before[series]; got != delta {
+			t.Errorf("expected %s to grow by %v, got %v", series, delta, got)
+		}
+	}
+}
@@ -1,14 +1,27 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"flag"
 
 	"github.com/mgutz/logxi/v1"
-	"github.com/tolleiv/nuimo"
+	"github.com/spf13/viper"
+	"github.com/tolleiv/nuimo-fhem/api"
 	"github.com/tolleiv/nuimo-fhem/fhem"
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+	"github.com/tolleiv/nuimo-fhem/record"
 	"github.com/tolleiv/nuimo-fhem/scenes"
+
+	// command handles which register themselves
+	_ "github.com/tolleiv/nuimo-fhem/mqtt"
+	_ "github.com/tolleiv/nuimo-fhem/shell"
+	_ "github.com/tolleiv/nuimo-fhem/webhook"
 )
 
 var logger = log.New("nuimo-fhem")
@@ -18,44 +31,118 @@ func main() {
 	fhemHost := flag.String("host", "localhost", "Hostname for the FHEM server")
 	fhemPort := flag.Int("port", 7072, "Telnet port of the FHEM server")
 	nuimoTtl := flag.Int("keepalive", 300, "Nuimo keepalive time in seconds")
+	dryRun := flag.Bool("dry-run", false, "Print the FHEM commands instead of sending them to the server")
+	httpAddress := flag.String("http", "", "Address for the HTTP API, e.g. :8090 (disabled by default)")
+	recordFile := flag.String("record", "", "Append all Nuimo events to the given file (JSON lines)")
+	stateFile := flag.String("state", "", "File to keep the current scene and other state across restarts")
+	shutdownTimeout := flag.Int("shutdown-timeout", 5, "Time in seconds to flush pending commands on shutdown")
 	flag.Parse()
 
-	device, _ := nuimo.Connect(*nuimoTtl)
-	defer device.Disconnect()
+	fhemAddress := fmt.Sprintf("%s:%d", *fhemHost, *fhemPort)
 
-	done := make(chan bool)
-	fhemCmds := make(chan string)
-	nuimoCmds := make(chan string)
-	outTerminal := make(chan string)
+	if flag.Arg(0) == "replay" {
+		replay(flag.Args()[1:], fhemAddress)
+		return
+	}
 
-	c := scenes.NewController()
-	c.AddCommandListener("fhem", fhemCmds)
-	c.AddCommandListener("nuimo", nuimoCmds)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 
-	f := &fhem.Fhem{Address: fmt.Sprintf("%s:%d", *fhemHost, *fhemPort)}
-	go f.Commands(fhemCmds, outTerminal)
+	ctx, cancel := context.WithCancel(context.Background())
+	timeout := time.Duration(*shutdownTimeout) * time.Second
 
-	go func(outputs <-chan string) {
-		for {
-			out, more := <-outputs
-			if !more {
-				return
-			}
-			logger.Info("Fhem output", out)
+	// the first discovery blocks until a Nuimo is in range, signals are served meanwhile
+	connected := make(chan *nuimo.Nuimo, 1)
+	go func() {
+		device, err := nuimo.Connect(*nuimoTtl)
+		if err != nil {
+			logger.Warn("Nuimo not connected yet, retrying in the background", "err", err)
 		}
-	}(outTerminal)
+		connected <- device
+	}()
+	var device *nuimo.Nuimo
+	select {
+	case device = <-connected:
+	case sig := <-signals:
+		logger.Info("Shutting down before the Nuimo was found", "signal", sig)
+		cancel()
+		return
+	}
+	defer device.Disconnect()
 
-	go func(icons <-chan string) {
-		for {
-			icon := <-icons
-			device.Display(iconToMatrix(icon), 255, 10)
+	c := scenes.NewController()
+	go c.WatchStates(device.States())
+	c.CountDropped(device.Dropped)
+	orientation := nuimo.Orientation{
+		Rotation:       viper.GetInt("nuimo.orientation"),
+		Mirrored:       viper.GetBool("nuimo.mirrored"),
+		InvertRotation: viper.GetBool("nuimo.invert_rotation"),
+	}
+	if err := device.SetOrientation(orientation); err != nil {
+		logger.Fatal("Invalid nuimo configuration", "err", err)
+	}
+	if *stateFile != "" {
+		if err := c.PersistState(*stateFile); err != nil {
+			logger.Error("Unable to restore state", "file", *stateFile, "err", err)
+		}
+	}
+
+	var f fhem.Client = &fhem.Fhem{Address: fhemAddress}
+	if *dryRun {
+		f = &fhem.DryRun{}
+		c.TraceCommands("fhem", os.Stdout)
+	}
+	if err := c.Handle("fhem", f, scenes.HandlerOptions{}); err != nil {
+		logger.Fatal("Invalid handler configuration", "err", err)
+	}
 
+	display := scenes.HandlerFunc(func(ctx context.Context, cmd scenes.Command) (scenes.Result, error) {
+		device.Display(iconToMatrix(cmd.Text), 255, 10)
+		return scenes.Result{}, nil
+	})
+	if err := c.Handle("nuimo", display, scenes.HandlerOptions{Overflow: scenes.DropOldest}); err != nil {
+		logger.Fatal("Invalid handler configuration", "err", err)
+	}
+
+	if err := c.HandleRegistered(); err != nil {
+		logger.Fatal("Invalid handler configuration", "err", err)
+	}
+	c.ReportLock()
+
+	var server *api.Server
+	if *httpAddress != "" {
+		server = &api.Server{Address: *httpAddress, Controller: c, Fhem: f}
+		go func() {
+			if err := server.ListenAndServe(); err != nil {
+				logger.Error("HTTP API stopped", "err", err)
+			}
+		}()
+	}
+
+	events := device.Events()
+	if *recordFile != "" {
+		file, err := os.OpenFile(*recordFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			logger.Fatal("Unable to open record file", "file", *recordFile, "err", err)
 		}
-	}(nuimoCmds)
+		defer file.Close()
+		events = record.Record(events, file)
+	}
+
+	go c.Listen(ctx, events)
 
-	go c.Listen(device.Events())
+	sig := <-signals
+	logger.Info("Shutting down", "signal", sig)
 
-	<-done
+	if server != nil {
+		shutdownCtx, done := context.WithTimeout(context.Background(), timeout)
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Warn("HTTP API not stopped in time", "err", err)
+		}
+		done()
+	}
+	cancel()
+	c.Shutdown(timeout)
 }
 
 func iconToMatrix(icon string) []byte {
@@ -123,6 +210,54 @@ func iconToMatrix(icon string) []byte {
 			1, 1, 1, 1, 1, 1, 1, 1, 1,
 			0, 1, 1, 0, 0, 0, 1, 1, 0,
 		)
+	case "battery":
+		matrix = nuimo.DisplayMatrix(
+			0, 0, 0, 1, 1, 1, 0, 0, 0,
+			0, 0, 1, 1, 1, 1, 1, 0, 0,
+			0, 0, 1, 0, 0, 0, 1, 0, 0,
+			0, 0, 1, 0, 0, 0, 1, 0, 0,
+			0, 0, 1, 0, 0, 0, 1, 0, 0,
+			0, 0, 1, 0, 0, 0, 1, 0, 0,
+			0, 0, 1, 1, 1, 1, 1, 0, 0,
+			0, 0, 1, 1, 1, 1, 1, 0, 0,
+			0, 0, 1, 1, 1, 1, 1, 0, 0,
+		)
+	case "question":
+		matrix = nuimo.DisplayMatrix(
+			0, 0, 0, 1, 1, 1, 0, 0, 0,
+			0, 0, 1, 0, 0, 0, 1, 0, 0,
+			0, 0, 0, 0, 0, 0, 1, 0, 0,
+			0, 0, 0, 0, 0, 1, 0, 0, 0,
+			0, 0, 0, 0, 1, 0, 0, 0, 0,
+			0, 0, 0, 0, 1, 0, 0, 0, 0,
+			0, 0, 0, 0, 0, 0, 0, 0, 0,
+			0, 0, 0, 0, 1, 0, 0, 0, 0,
+			0, 0, 0, 0, 0, 0, 0, 0, 0,
+		)
+	case "lock":
+		matrix = nuimo.DisplayMatrix(
+			0, 0, 0, 1, 1, 1, 0, 0, 0,
+			0, 0, 1, 0, 0, 0, 1, 0, 0,
+			0, 0, 1, 0, 0, 0, 1, 0, 0,
+			0, 1, 1, 1, 1, 1, 1, 1, 0,
+			0, 1, 1, 1, 1, 1, 1, 1, 0,
+			0, 1, 1, 1, 0, 1, 1, 1, 0,
+			0, 1, 1, 1, 0, 1, 1, 1, 0,
+			0, 1, 1, 1, 1, 1, 1, 1, 0,
+			0, 0, 0, 0, 0, 0, 0, 0, 0,
+		)
+	case "cross":
+		matrix = nuimo.DisplayMatrix(
+			0, 0, 0, 0, 0, 0, 0, 0, 0,
+			0, 1, 0, 0, 0, 0, 0, 1, 0,
+			0, 0, 1, 0, 0, 0, 1, 0, 0,
+			0, 0, 0, 1, 0, 1, 0, 0, 0,
+			0, 0, 0, 0, 1, 0, 0, 0, 0,
+			0, 0, 0, 1, 0, 1, 0, 0, 0,
+			0, 0, 1, 0, 0, 0, 1, 0, 0,
+			0, 1, 0, 0, 0, 0, 0, 1, 0,
+			0, 0, 0, 0, 0, 0, 0, 0, 0,
+		)
 	default:
 		matrix = nuimo.DisplayMatrix(
 			0, 0, 0, 0, 0, 0, 0, 0, 0,
@@ -0,0 +1,286 @@
+// Package metrics provides counters, gauges and histograms which are exposed in the
+// Prometheus text format.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram buckets in seconds used for latencies
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+var registry struct {
+	sync.Mutex
+	metrics []metric
+}
+
+func register(m metric) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.metrics = append(registry.metrics, m)
+}
+
+// Handler serves all registered metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registry.Lock()
+		metrics := append([]metric{}, registry.metrics...)
+		registry.Unlock()
+
+		buf := new(bytes.Buffer)
+		for _, m := range metrics {
+			m.write(buf)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(buf.Bytes())
+	})
+}
+
+// CounterVec is a set of counters partitioned by label values
+type CounterVec struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	labels []string
+	values map[string]float64
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	register(c)
+	return c
+}
+
+// Inc increments the counter for the given label values by one
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter for the given label values by v
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[labelString(c.labels, labelValues)] += v
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeHeader(w, c.name, c.help, "counter")
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+	writeValues(w, c.name, c.values)
+}
+
+// Gauge is a single value which can go up and down
+type Gauge struct {
+	mu    sync.Mutex
+	name  string
+	help  string
+	value float64
+}
+
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	register(g)
+	return g
+}
+
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.value = v
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.value))
+}
+
+// GaugeVec is a set of gauges partitioned by label values
+type GaugeVec struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	labels []string
+	values map[string]float64
+}
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	register(g)
+	return g
+}
+
+// Set sets the gauge for the given label values
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[labelString(g.labels, labelValues)] = v
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	writeHeader(w, g.name, g.help, "gauge")
+	writeValues(w, g.name, g.values)
+}
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	buckets []float64
+	series  *series
+}
+
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{name: name, help: help, buckets: buckets, series: newSeries(buckets)}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.series.observe(h.buckets, v)
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(w, h.name, h.help, "histogram")
+	h.series.write(w, h.name, h.buckets, "")
+}
+
+// HistogramVec is a set of histograms partitioned by label values
+type HistogramVec struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	buckets []float64
+	labels  []string
+	values  map[string]*series
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, buckets: buckets, labels: labels, values: make(map[string]*series)}
+	register(h)
+	return h
+}
+
+// Observe adds the observation to the histogram for the given label values
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := labelString(h.labels, labelValues)
+	if h.values[key] == nil {
+		h.values[key] = newSeries(h.buckets)
+	}
+	h.values[key].observe(h.buckets, v)
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(w, h.name, h.help, "histogram")
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		h.values[key].write(w, h.name, h.buckets, key)
+	}
+}
+
+// series are the bucket counts, the number and the sum of the observations of a histogram
+type series struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newSeries(buckets []float64) *series {
+	return &series{counts: make([]uint64, len(buckets))}
+}
+
+func (s *series) observe(buckets []float64, v float64) {
+	for i, upper := range buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// write writes the series with the given labels, e.g. {result="ok"}, the bucket label is added
+func (s *series) write(w io.Writer, name string, buckets []float64, labels string) {
+	prefix := strings.TrimSuffix(strings.TrimPrefix(labels, "{"), "}")
+	if prefix != "" {
+		prefix += ","
+	}
+	for i, upper := range buckets {
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, prefix, formatFloat(upper), s.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, prefix, s.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatFloat(s.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, s.count)
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+func writeValues(w io.Writer, name string, values map[string]float64) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", name, key, formatFloat(values[key]))
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelString(labels, values []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(labels))
+	for i, label := range labels {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", label, labelEscaper.Replace(value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,292 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	packetConnect    = 0x10
+	packetConnack    = 0x20
+	packetPublish    = 0x30
+	packetPuback     = 0x40
+	packetPingreq    = 0xC0
+	packetDisconnect = 0xE0
+)
+
+var (
+	// ackTimeout is the longest time to wait for the CONNACK and for a PUBACK
+	ackTimeout = 10 * time.Second
+	// writeTimeout is the time a broker which doesn't read anymore may block a write
+	writeTimeout = 10 * time.Second
+)
+
+// client is a minimal MQTT 3.1.1 client which is only able to publish messages.
+// It connects lazily and reconnects on the next publish after the connection broke, until
+// it is closed.
+type client struct {
+	address   string
+	clientID  string
+	username  string
+	password  string
+	keepAlive time.Duration
+
+	mu     sync.Mutex
+	conn   net.Conn
+	closed bool
+	nextID uint16
+	acks   map[uint16]chan bool
+}
+
+func newClient(broker, clientID, username, password string) *client {
+	address := strings.TrimPrefix(strings.TrimPrefix(broker, "tcp://"), "mqtt://")
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "1883")
+	}
+	return &client{
+		address:   address,
+		clientID:  clientID,
+		username:  username,
+		password:  password,
+		keepAlive: 60 * time.Second,
+		acks:      make(map[uint16]chan bool),
+	}
+}
+
+// publish sends the message, with QoS 1 it waits until the broker acknowledged it or the
+// context is done
+func (c *client) publish(ctx context.Context, topic string, payload []byte, qos byte, retain bool) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return errors.New("MQTT client closed")
+	}
+	if c.conn == nil {
+		if err := c.connect(ctx); err != nil {
+			c.mu.Unlock()
+			return err
+		}
+	}
+
+	header := byte(packetPublish) | qos<<1
+	if retain {
+		header |= 1
+	}
+	body := new(bytes.Buffer)
+	writeString(body, topic)
+
+	var ack chan bool
+	var id uint16
+	if qos > 0 {
+		c.nextID++
+		if c.nextID == 0 {
+			c.nextID = 1
+		}
+		id = c.nextID
+		binary.Write(body, binary.BigEndian, id)
+		ack = make(chan bool, 1)
+		c.acks[id] = ack
+	}
+	body.Write(payload)
+
+	err := write(c.conn, header, body.Bytes())
+	if err != nil {
+		delete(c.acks, id)
+		c.drop(c.conn)
+	}
+	c.mu.Unlock()
+	if err != nil || ack == nil {
+		return err
+	}
+
+	select {
+	case <-ack:
+		return nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.acks, id)
+		c.mu.Unlock()
+		return fmt.Errorf("No acknowledgement for message %d on %s: %s", id, topic, ctx.Err())
+	}
+}
+
+// close sends a DISCONNECT and closes the connection, later publishes fail
+func (c *client) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if c.conn != nil {
+		write(c.conn, packetDisconnect, nil)
+		c.drop(c.conn)
+	}
+}
+
+// connect has to be called with the lock held
+func (c *client) connect(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", c.address)
+	if err != nil {
+		return err
+	}
+
+	flags := byte(0x02) // clean session
+	if c.username != "" {
+		flags |= 0x80
+	}
+	if c.password != "" {
+		flags |= 0x40
+	}
+	body := new(bytes.Buffer)
+	writeString(body, "MQTT")
+	body.WriteByte(4) // protocol level 3.1.1
+	body.WriteByte(flags)
+	binary.Write(body, binary.BigEndian, uint16(c.keepAlive/time.Second))
+	writeString(body, c.clientID)
+	if c.username != "" {
+		writeString(body, c.username)
+	}
+	if c.password != "" {
+		writeString(body, c.password)
+	}
+
+	if err := write(conn, packetConnect, body.Bytes()); err != nil {
+		conn.Close()
+		return err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(ackTimeout))
+	r := bufio.NewReader(conn)
+	header, payload, err := readPacket(r)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if header&0xF0 != packetConnack || len(payload) != 2 {
+		conn.Close()
+		return errors.New("Unexpected answer to CONNECT")
+	}
+	if payload[1] != 0 {
+		conn.Close()
+		return fmt.Errorf("Connection refused by broker, return code %d", payload[1])
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	logger.Info("Connected to MQTT broker", "address", c.address)
+	c.conn = conn
+	go c.read(conn, r)
+	go c.ping(conn)
+	return nil
+}
+
+// drop has to be called with the lock held
+func (c *client) drop(conn net.Conn) {
+	conn.Close()
+	if c.conn == conn {
+		c.conn = nil
+	}
+}
+
+func (c *client) read(conn net.Conn, r *bufio.Reader) {
+	for {
+		header, payload, err := readPacket(r)
+		if err != nil {
+			c.mu.Lock()
+			if c.conn == conn {
+				logger.Warn("MQTT connection lost", "err", err)
+			}
+			c.drop(conn)
+			c.mu.Unlock()
+			return
+		}
+		if header&0xF0 == packetPuback && len(payload) == 2 {
+			id := binary.BigEndian.Uint16(payload)
+			c.mu.Lock()
+			if ack, ok := c.acks[id]; ok {
+				ack <- true
+				delete(c.acks, id)
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+func (c *client) ping(conn net.Conn) {
+	ticker := time.NewTicker(c.keepAlive / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.mu.Lock()
+		if c.conn != conn {
+			c.mu.Unlock()
+			return
+		}
+		if err := write(conn, packetPingreq, nil); err != nil {
+			c.drop(conn)
+		}
+		c.mu.Unlock()
+	}
+}
+
+func writeString(w *bytes.Buffer, s string) {
+	binary.Write(w, binary.BigEndian, uint16(len(s)))
+	w.WriteString(s)
+}
+
+// write sends a packet, a broker which doesn't read anymore fails it after the write timeout
+func write(conn net.Conn, header byte, body []byte) error {
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return writePacket(conn, header, body)
+}
+
+func writePacket(w io.Writer, header byte, body []byte) error {
+	packet := []byte{header}
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+	packet = append(packet, body...)
+	_, err := w.Write(packet)
+	return err
+}
+
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length := 0
+	multiplier := 1
+	for i := 0; ; i++ {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7F) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("Malformed remaining length")
+		}
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	return header, payload, err
+}
@@ -0,0 +1,274 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type published struct {
+	topic   string
+	payload string
+	qos     byte
+	retain  bool
+}
+
+// broker is a stand-in for an MQTT broker which accepts every connection
+type broker struct {
+	listener net.Listener
+	// code is returned in the CONNACK
+	code byte
+	// noAck suppresses the PUBACK for QoS 1 messages
+	noAck bool
+	// dropAfter closes the connection after the given number of messages
+	dropAfter int
+	// stall stops reading after the CONNACK until it is closed
+	stall chan struct{}
+
+	mu        sync.Mutex
+	connects  [][]byte
+	published []published
+	received  chan published
+}
+
+func newBroker(t *testing.T) *broker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &broker{listener: l, received: make(chan published, 16)}
+	go b.serve()
+	return b
+}
+
+func (b *broker) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *broker) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	count := 0
+	for {
+		header, payload, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		switch header & 0xF0 {
+		case packetConnect:
+			b.mu.Lock()
+			b.connects = append(b.connects, payload)
+			b.mu.Unlock()
+			writePacket(conn, packetConnack, []byte{0, b.code})
+			if b.stall != nil {
+				<-b.stall
+				return
+			}
+		case packetPublish:
+			qos := header >> 1 & 0x03
+			length := int(binary.BigEndian.Uint16(payload))
+			msg := published{topic: string(payload[2 : 2+length]), qos: qos, retain: header&1 == 1}
+			rest := payload[2+length:]
+			if qos > 0 {
+				if !b.noAck {
+					writePacket(conn, packetPuback, rest[:2])
+				}
+				rest = rest[2:]
+			}
+			msg.payload = string(rest)
+			b.mu.Lock()
+			b.published = append(b.published, msg)
+			b.mu.Unlock()
+			b.received <- msg
+			count++
+			if b.dropAfter > 0 && count == b.dropAfter {
+				return
+			}
+		}
+	}
+}
+
+func (b *broker) connectCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.connects)
+}
+
+func (b *broker) next(t *testing.T) published {
+	select {
+	case msg := <-b.received:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no message received by the broker")
+	}
+	return published{}
+}
+
+func TestConnect(t *testing.T) {
+	b := newBroker(t)
+	defer b.listener.Close()
+
+	c := newClient(b.listener.Addr().String(), "nuimo-test", "user", "secret")
+	defer c.close()
+	if err := c.publish(context.Background(), "home/lamp", []byte("ON"), 0, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := b.connectCount(); got != 1 {
+		t.Fatalf("expected a single CONNECT, got %d", got)
+	}
+	want := new(bytes.Buffer)
+	writeString(want, "MQTT")
+	want.Write([]byte{4, 0xC2, 0, 60})
+	writeString(want, "nuimo-test")
+	writeString(want, "user")
+	writeString(want, "secret")
+	if got := b.connects[0]; !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("unexpected CONNECT payload %v, want %v", got, want.Bytes())
+	}
+
+	msg := b.next(t)
+	if msg != (published{topic: "home/lamp", payload: "ON", retain: true}) {
+		t.Errorf("unexpected message %+v", msg)
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	b := newBroker(t)
+	b.code = 5
+	defer b.listener.Close()
+
+	c := newClient(b.listener.Addr().String(), "nuimo-test", "", "")
+	if err := c.publish(context.Background(), "home/lamp", []byte("ON"), 0, false); err == nil {
+		t.Fatal("expected the refused connection to fail the publish")
+	}
+}
+
+func TestPublishQoS1(t *testing.T) {
+	b := newBroker(t)
+	defer b.listener.Close()
+
+	c := newClient(b.listener.Addr().String(), "nuimo-test", "", "")
+	defer c.close()
+	for i := 0; i < 3; i++ {
+		if err := c.publish(context.Background(), "home/lamp", []byte("ON"), 1, false); err != nil {
+			t.Fatal(err)
+		}
+		if msg := b.next(t); msg.qos != 1 {
+			t.Errorf("expected QoS 1, got %d", msg.qos)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.acks) != 0 {
+		t.Errorf("expected all acknowledgements to be consumed, %d left", len(c.acks))
+	}
+}
+
+func TestPublishQoS1Timeout(t *testing.T) {
+	b := newBroker(t)
+	b.noAck = true
+	defer b.listener.Close()
+
+	c := newClient(b.listener.Addr().String(), "nuimo-test", "", "")
+	defer c.close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := c.publish(ctx, "home/lamp", []byte("ON"), 1, false); err == nil {
+		t.Fatal("expected the missing PUBACK to fail the publish")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("publish took %s, expected the context to end the wait", d)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.acks) != 0 {
+		t.Errorf("expected the pending acknowledgement to be removed")
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	b := newBroker(t)
+	b.dropAfter = 1
+	defer b.listener.Close()
+
+	c := newClient(b.listener.Addr().String(), "nuimo-test", "", "")
+	defer c.close()
+	if err := c.publish(context.Background(), "home/lamp", []byte("ON"), 1, false); err != nil {
+		t.Fatal(err)
+	}
+	b.next(t)
+
+	// wait until the client noticed the dropped connection
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.mu.Lock()
+		conn := c.conn
+		c.mu.Unlock()
+		if conn == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the dropped connection was not noticed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := c.publish(context.Background(), "home/lamp", []byte("OFF"), 1, false); err != nil {
+		t.Fatal(err)
+	}
+	if msg := b.next(t); msg.payload != "OFF" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	if got := b.connectCount(); got != 2 {
+		t.Errorf("expected a reconnect, got %d connects", got)
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	defer func(d time.Duration) { writeTimeout = d }(writeTimeout)
+	writeTimeout = 100 * time.Millisecond
+
+	b := newBroker(t)
+	b.stall = make(chan struct{})
+	defer close(b.stall)
+	defer b.listener.Close()
+
+	c := newClient(b.listener.Addr().String(), "nuimo-test", "", "")
+	defer c.close()
+	// the payload is larger than the socket buffers, so the write blocks
+	payload := make([]byte, 32<<20)
+	start := time.Now()
+	if err := c.publish(context.Background(), "home/lamp", payload, 0, false); err == nil {
+		t.Fatal("expected the blocked write to fail")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("publish took %s, expected the write timeout to apply", d)
+	}
+}
+
+func TestPublishAfterClose(t *testing.T) {
+	b := newBroker(t)
+	defer b.listener.Close()
+
+	c := newClient(b.listener.Addr().String(), "nuimo-test", "", "")
+	c.close()
+	if err := c.publish(context.Background(), "home/lamp", []byte("ON"), 0, false); err == nil {
+		t.Fatal("expected a closed client to refuse the publish")
+	}
+	if got := b.connectCount(); got != 0 {
+		t.Errorf("expected no connect after close, got %d", got)
+	}
+}
@@ -0,0 +1,200 @@
+// Package mqtt publishes scene commands and Nuimo events to an MQTT broker.
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mgutz/logxi/v1"
+	"github.com/spf13/viper"
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+	"github.com/tolleiv/nuimo-fhem/scenes"
+)
+
+var logger = log.New("mqtt")
+
+type Mqtt struct {
+	// Topic is the base of the topic tree events and scene changes are published to
+	Topic  string
+	QoS    byte
+	Retain bool
+	client *client
+	// outbox keeps events and scene changes in the order they are published
+	outbox chan message
+	// done is closed by Close to stop publishing the outbox
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type message struct {
+	topic   string
+	payload string
+	qos     byte
+	retain  bool
+}
+
+func init() {
+	scenes.Register("mqtt", func() (scenes.Handler, scenes.HandlerOptions, error) {
+		m, err := FromConfig()
+		if m == nil {
+			return nil, scenes.HandlerOptions{}, err
+		}
+		return m, scenes.HandlerOptions{}, nil
+	})
+}
+
+// FromConfig creates the MQTT publisher from the mqtt section of the configuration.
+// It returns nil when no broker is configured.
+func FromConfig() (*Mqtt, error) {
+	broker := viper.GetString("mqtt.broker")
+	if broker == "" {
+		return nil, nil
+	}
+	qos := viper.GetInt("mqtt.qos")
+	if qos < 0 || qos > 1 {
+		return nil, fmt.Errorf("Unsupported QoS %d", qos)
+	}
+	clientID := viper.GetString("mqtt.client_id")
+	if clientID == "" {
+		clientID = "nuimo-fhem"
+	}
+
+	m := newMqtt(newClient(broker, clientID, viper.GetString("mqtt.username"), viper.GetString("mqtt.password")))
+	m.Topic = strings.TrimSuffix(viper.GetString("mqtt.topic"), "/")
+	m.QoS = byte(qos)
+	m.Retain = viper.GetBool("mqtt.retain")
+	go m.publishEvents()
+	return m, nil
+}
+
+func newMqtt(c *client) *Mqtt {
+	return &Mqtt{client: c, outbox: make(chan message, 32), done: make(chan struct{})}
+}
+
+// Handle publishes a message. A command consists of the topic followed by the payload,
+// optionally prefixed with "qos=0|1" and "retain" options, e.g. "qos=1 retain zigbee2mqtt/lamp/set ON".
+func (m *Mqtt) Handle(ctx context.Context, cmd scenes.Command) (scenes.Result, error) {
+	logger.Debug("Trigger command", cmd.Text)
+	msg, err := parseCommand(cmd.Text, m.QoS, m.Retain)
+	if err != nil {
+		return scenes.Result{}, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, ackTimeout)
+	defer cancel()
+	if err := m.client.publish(ctx, msg.topic, []byte(msg.payload), msg.qos, msg.retain); err != nil {
+		return scenes.Result{}, err
+	}
+	return scenes.Result{Output: "ok"}, nil
+}
+
+// Close stops publishing events and scene changes and disconnects from the broker. Messages
+// still queued are dropped.
+func (m *Mqtt) Close() error {
+	m.closeOnce.Do(func() { close(m.done) })
+	m.client.close()
+	return nil
+}
+
+// ObserveEvent publishes the event to <topic>/event/<key> with the event value as payload.
+// Events are dropped instead of blocking the event stream when the broker can't keep up.
+func (m *Mqtt) ObserveEvent(event nuimo.Event) {
+	if m.Topic == "" {
+		return
+	}
+	msg := message{
+		topic:   m.Topic + "/event/" + event.Key,
+		payload: strconv.FormatInt(event.Value, 10),
+		qos:     m.QoS,
+	}
+	select {
+	case m.outbox <- msg:
+	case <-m.done:
+	default:
+		logger.Warn("Event not published, queue is full", "key", event.Key)
+	}
+}
+
+// ObserveScene publishes the scene name as retained message to <topic>/scene. Scene
+// changes are never dropped, the oldest queued message makes room when the queue is full.
+func (m *Mqtt) ObserveScene(name string) {
+	if m.Topic == "" {
+		return
+	}
+	msg := message{topic: m.Topic + "/scene", payload: name, qos: m.QoS, retain: true}
+	for {
+		select {
+		case m.outbox <- msg:
+			return
+		case <-m.done:
+			return
+		default:
+		}
+		select {
+		case dropped := <-m.outbox:
+			logger.Warn("Message not published, queue is full", "topic", dropped.topic)
+		default:
+		}
+	}
+}
+
+// publishEvents publishes the outbox until the publisher is closed
+func (m *Mqtt) publishEvents() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-m.done
+		cancel()
+	}()
+	for {
+		select {
+		case msg := <-m.outbox:
+			publishCtx, done := context.WithTimeout(ctx, ackTimeout)
+			err := m.client.publish(publishCtx, msg.topic, []byte(msg.payload), msg.qos, msg.retain)
+			done()
+			if err != nil {
+				logger.Warn("Unable to publish message", "topic", msg.topic, "err", err)
+			}
+		case <-m.done:
+			return
+		}
+	}
+}
+
+func parseCommand(command string, qos byte, retain bool) (message, error) {
+	msg := message{qos: qos, retain: retain}
+	rest := strings.TrimSpace(command)
+	for {
+		parts := strings.SplitN(rest, " ", 2)
+		token := parts[0]
+		switch {
+		case token == "retain":
+			msg.retain = true
+		case strings.HasPrefix(token, "retain="):
+			b, err := strconv.ParseBool(strings.TrimPrefix(token, "retain="))
+			if err != nil {
+				return msg, fmt.Errorf("Invalid retain option %s", token)
+			}
+			msg.retain = b
+		case strings.HasPrefix(token, "qos="):
+			n, err := strconv.Atoi(strings.TrimPrefix(token, "qos="))
+			if err != nil || n < 0 || n > 1 {
+				return msg, fmt.Errorf("Unsupported QoS option %s", token)
+			}
+			msg.qos = byte(n)
+		default:
+			msg.topic = token
+			if len(parts) == 2 {
+				msg.payload = parts[1]
+			}
+			return msg, nil
+		}
+		if len(parts) < 2 {
+			return msg, errors.New("Missing topic in command " + command)
+		}
+		rest = strings.TrimLeft(parts[1], " ")
+	}
+}
@@ -0,0 +1,132 @@
+package mqtt
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+	"github.com/tolleiv/nuimo-fhem/scenes"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    message
+		err     bool
+	}{
+		{"home/lamp ON", message{topic: "home/lamp", payload: "ON"}, false},
+		{"home/lamp", message{topic: "home/lamp"}, false},
+		{"qos=1 retain home/lamp ON", message{topic: "home/lamp", payload: "ON", qos: 1, retain: true}, false},
+		{"retain=false home/lamp {\"state\": \"ON\"}", message{topic: "home/lamp", payload: "{\"state\": \"ON\"}"}, false},
+		{"qos=2 home/lamp ON", message{}, true},
+		{"retain=maybe home/lamp ON", message{}, true},
+		{"retain", message{}, true},
+	}
+	for _, test := range tests {
+		got, err := parseCommand(test.command, 0, false)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.command)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", test.command, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%q: got %+v, want %+v", test.command, got, test.want)
+		}
+	}
+}
+
+func TestObserveOrder(t *testing.T) {
+	b := newBroker(t)
+	defer b.listener.Close()
+
+	m := newMqtt(newClient(b.listener.Addr().String(), "nuimo-test", "", ""))
+	m.Topic = "nuimo"
+	defer m.Close()
+
+	m.ObserveEvent(nuimo.Event{Key: "press", Value: 1})
+	m.ObserveScene("light")
+	m.ObserveEvent(nuimo.Event{Key: "release", Value: 0})
+	m.ObserveScene("media")
+	go m.publishEvents()
+
+	want := []published{
+		{topic: "nuimo/event/press", payload: "1"},
+		{topic: "nuimo/scene", payload: "light", retain: true},
+		{topic: "nuimo/event/release", payload: "0"},
+		{topic: "nuimo/scene", payload: "media", retain: true},
+	}
+	for _, w := range want {
+		if got := b.next(t); got != w {
+			t.Errorf("got %+v, want %+v", got, w)
+		}
+	}
+}
+
+func TestObserveSceneQueueFull(t *testing.T) {
+	m := &Mqtt{Topic: "nuimo", outbox: make(chan message, 2)}
+	m.ObserveEvent(nuimo.Event{Key: "press", Value: 1})
+	m.ObserveEvent(nuimo.Event{Key: "release", Value: 0})
+	m.ObserveEvent(nuimo.Event{Key: "press", Value: 1})
+	m.ObserveScene("light")
+
+	if got := (<-m.outbox).topic; got != "nuimo/event/release" {
+		t.Errorf("expected the oldest event to be dropped, got %s first", got)
+	}
+	if got := (<-m.outbox).payload; got != "light" {
+		t.Errorf("expected the scene change to be queued, got %s", got)
+	}
+}
+
+func TestCloseStopsPublishing(t *testing.T) {
+	b := newBroker(t)
+	defer b.listener.Close()
+
+	m := newMqtt(newClient(b.listener.Addr().String(), "nuimo-test", "", ""))
+	m.Topic = "nuimo"
+	stopped := make(chan bool)
+	go func() {
+		m.publishEvents()
+		close(stopped)
+	}()
+	m.ObserveScene("light")
+	b.next(t)
+
+	m.Close()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("expected Close to stop publishing")
+	}
+	m.ObserveEvent(nuimo.Event{Key: "press", Value: 1})
+	m.ObserveScene("media")
+	if _, err := m.Handle(context.Background(), scenes.Command{Text: "home/lamp ON"}); err == nil {
+		t.Error("expected commands to fail after Close")
+	}
+	if got := b.connectCount(); got != 1 {
+		t.Errorf("expected no reconnect after Close, got %d connects", got)
+	}
+}
+
+func TestHandleHonoursContext(t *testing.T) {
+	b := newBroker(t)
+	b.noAck = true
+	defer b.listener.Close()
+
+	m := newMqtt(newClient(b.listener.Addr().String(), "nuimo-test", "", ""))
+	defer m.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := m.Handle(ctx, scenes.Command{Text: "qos=1 home/lamp ON"}); err == nil {
+		t.Fatal("expected the missing PUBACK to fail the command")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("command took %s, expected the context to end the wait", d)
+	}
+}
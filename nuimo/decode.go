@@ -0,0 +1,130 @@
+package nuimo
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Decoder turns the payload of a notification into events. Decoders do not depend on a
+// connection, invalid payloads are reported as error.
+type Decoder func(req []byte) ([]Event, error)
+
+func expectLength(name string, req []byte, length int) error {
+	if len(req) < length {
+		return fmt.Errorf("%s: payload %x too short, expected %d bytes", name, req, length)
+	}
+	return nil
+}
+
+// DecodeBattery decodes the battery level in percent
+func DecodeBattery(req []byte) ([]Event, error) {
+	if err := expectLength("battery", req, 1); err != nil {
+		return nil, err
+	}
+	return []Event{{Key: "battery", Raw: req, Value: int64(req[0])}}, nil
+}
+
+// DecodeClick decodes presses and releases of the button
+func DecodeClick(req []byte) ([]Event, error) {
+	if err := expectLength("click", req, 1); err != nil {
+		return nil, err
+	}
+	switch req[0] {
+	case CLICK_DOWN:
+		return []Event{{Key: "press", Raw: req}}, nil
+	case CLICK_UP:
+		return []Event{{Key: "release", Raw: req}}, nil
+	}
+	return nil, fmt.Errorf("click: unknown value %d", req[0])
+}
+
+// DecodeRotate decodes the rotation, positive values are clockwise
+func DecodeRotate(req []byte) ([]Event, error) {
+	if err := expectLength("rotate", req, 2); err != nil {
+		return nil, err
+	}
+	val := int64(int16(binary.LittleEndian.Uint16(req)))
+	return []Event{{Key: "rotate", Raw: req, Value: val}}, nil
+}
+
+// DecodeSwipe decodes swipes into a generic swipe event with the direction as value
+// followed by the event of the direction. Touches and long touches of the edges are
+// reported on the same characteristic.
+func DecodeSwipe(req []byte) ([]Event, error) {
+	if err := expectLength("swipe", req, 1); err != nil {
+		return nil, err
+	}
+	dir := int64(req[0])
+	events := []Event{{Key: "swipe", Raw: req, Value: dir}}
+
+	switch dir {
+	case DIR_LEFT:
+		events = append(events, Event{Key: "swipe_left", Raw: req})
+	case DIR_RIGHT:
+		events = append(events, Event{Key: "swipe_right", Raw: req})
+	case DIR_UP:
+		events = append(events, Event{Key: "swipe_up", Raw: req})
+	case DIR_DOWN:
+		events = append(events, Event{Key: "swipe_down", Raw: req})
+	case TOUCH_LEFT:
+		events = append(events, Event{Key: "touch_left", Raw: req})
+	case TOUCH_RIGHT:
+		events = append(events, Event{Key: "touch_right", Raw: req})
+	case TOUCH_TOP:
+		events = append(events, Event{Key: "touch_top", Raw: req})
+	case TOUCH_BOTTOM:
+		events = append(events, Event{Key: "touch_bottom", Raw: req})
+	case LONGTOUCH_LEFT:
+		events = append(events, Event{Key: "longtouch_left", Raw: req})
+	case LONGTOUCH_RIGHT:
+		events = append(events, Event{Key: "longtouch_right", Raw: req})
+	case LONGTOUCH_TOP:
+		events = append(events, Event{Key: "longtouch_top", Raw: req})
+	case LONGTOUCH_BOTTOM:
+		events = append(events, Event{Key: "longtouch_bottom", Raw: req})
+	default:
+		logger.Warn("Unknown swipe direction", "value", dir)
+	}
+	return events, nil
+}
+
+// DecodeFly decodes fly gestures with the distance as value
+func DecodeFly(req []byte) ([]Event, error) {
+	if err := expectLength("fly", req, 3); err != nil {
+		return nil, err
+	}
+	distance := int64(req[2])
+
+	switch req[0] {
+	case DIR_LEFT:
+		return []Event{{Key: "fly_left", Raw: req, Value: distance}}, nil
+	case DIR_RIGHT:
+		return []Event{{Key: "fly_right", Raw: req, Value: distance}}, nil
+	case DIR_BACKWARDS:
+		return []Event{{Key: "fly_backwards", Raw: req, Value: distance}}, nil
+	case DIR_TOWARDS:
+		return []Event{{Key: "fly_towards", Raw: req, Value: distance}}, nil
+	case DIR_UPDOWN:
+		return []Event{{Key: "fly_updown", Raw: req, Value: distance}}, nil
+	}
+	return nil, fmt.Errorf("fly: unknown direction %d", req[0])
+}
+
+// DecodeUnknown passes the payload of unknown characteristics on
+func DecodeUnknown(req []byte) ([]Event, error) {
+	return []Event{{Key: "unknown", Raw: req}}, nil
+}
+
+// handler sends the decoded events, invalid payloads are logged and dropped
+func (n *Nuimo) handler(decode Decoder) func(req []byte) {
+	return func(req []byte) {
+		events, err := decode(req)
+		if err != nil {
+			logger.Warn("Invalid notification", "err", err)
+			return
+		}
+		for _, e := range events {
+			n.send(e)
+		}
+	}
+}
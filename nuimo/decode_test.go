@@ -0,0 +1,104 @@
+package nuimo
+
+import (
+	"reflect"
+	"testing"
+)
+
+type decodeTest struct {
+	name    string
+	payload []byte
+	// keys and values of the expected events
+	keys   []string
+	values []int64
+	err    bool
+}
+
+func runDecodeTests(t *testing.T, decode Decoder, tests []decodeTest) {
+	for _, test := range tests {
+		events, err := decode(test.payload)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error for %x, got %v", test.name, test.payload, events)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.name, err)
+			continue
+		}
+		var keys []string
+		var values []int64
+		for _, e := range events {
+			keys = append(keys, e.Key)
+			values = append(values, e.Value)
+			if !reflect.DeepEqual(e.Raw, test.payload) {
+				t.Errorf("%s: expected the raw payload %x, got %x", test.name, test.payload, e.Raw)
+			}
+		}
+		if !reflect.DeepEqual(keys, test.keys) || !reflect.DeepEqual(values, test.values) {
+			t.Errorf("%s: expected %v %v, got %v %v", test.name, test.keys, test.values, keys, values)
+		}
+	}
+}
+
+func TestDecodeClick(t *testing.T) {
+	runDecodeTests(t, DecodeClick, []decodeTest{
+		{name: "press", payload: []byte{CLICK_DOWN}, keys: []string{"press"}, values: []int64{0}},
+		{name: "release", payload: []byte{CLICK_UP}, keys: []string{"release"}, values: []int64{0}},
+		{name: "trailing bytes", payload: []byte{CLICK_DOWN, 0xFF}, keys: []string{"press"}, values: []int64{0}},
+		{name: "empty", payload: []byte{}, err: true},
+		{name: "nil", payload: nil, err: true},
+		{name: "unknown", payload: []byte{7}, err: true},
+	})
+}
+
+func TestDecodeRotate(t *testing.T) {
+	runDecodeTests(t, DecodeRotate, []decodeTest{
+		{name: "clockwise", payload: []byte{0x10, 0x00}, keys: []string{"rotate"}, values: []int64{16}},
+		{name: "counter clockwise", payload: []byte{0xF0, 0xFF}, keys: []string{"rotate"}, values: []int64{-16}},
+		{name: "large", payload: []byte{0x00, 0x01}, keys: []string{"rotate"}, values: []int64{256}},
+		{name: "short", payload: []byte{0x10}, err: true},
+		{name: "empty", payload: []byte{}, err: true},
+	})
+}
+
+func TestDecodeSwipe(t *testing.T) {
+	runDecodeTests(t, DecodeSwipe, []decodeTest{
+		{name: "left", payload: []byte{DIR_LEFT}, keys: []string{"swipe", "swipe_left"}, values: []int64{DIR_LEFT, 0}},
+		{name: "right", payload: []byte{DIR_RIGHT}, keys: []string{"swipe", "swipe_right"}, values: []int64{DIR_RIGHT, 0}},
+		{name: "up", payload: []byte{DIR_UP}, keys: []string{"swipe", "swipe_up"}, values: []int64{DIR_UP, 0}},
+		{name: "down", payload: []byte{DIR_DOWN}, keys: []string{"swipe", "swipe_down"}, values: []int64{DIR_DOWN, 0}},
+		{name: "touch left", payload: []byte{TOUCH_LEFT}, keys: []string{"swipe", "touch_left"}, values: []int64{TOUCH_LEFT, 0}},
+		{name: "touch right", payload: []byte{TOUCH_RIGHT}, keys: []string{"swipe", "touch_right"}, values: []int64{TOUCH_RIGHT, 0}},
+		{name: "touch top", payload: []byte{TOUCH_TOP}, keys: []string{"swipe", "touch_top"}, values: []int64{TOUCH_TOP, 0}},
+		{name: "touch bottom", payload: []byte{TOUCH_BOTTOM}, keys: []string{"swipe", "touch_bottom"}, values: []int64{TOUCH_BOTTOM, 0}},
+		{name: "longtouch left", payload: []byte{LONGTOUCH_LEFT}, keys: []string{"swipe", "longtouch_left"}, values: []int64{LONGTOUCH_LEFT, 0}},
+		{name: "longtouch right", payload: []byte{LONGTOUCH_RIGHT}, keys: []string{"swipe", "longtouch_right"}, values: []int64{LONGTOUCH_RIGHT, 0}},
+		{name: "longtouch top", payload: []byte{LONGTOUCH_TOP}, keys: []string{"swipe", "longtouch_top"}, values: []int64{LONGTOUCH_TOP, 0}},
+		{name: "longtouch bottom", payload: []byte{LONGTOUCH_BOTTOM}, keys: []string{"swipe", "longtouch_bottom"}, values: []int64{LONGTOUCH_BOTTOM, 0}},
+		{name: "unknown", payload: []byte{42}, keys: []string{"swipe"}, values: []int64{42}},
+		{name: "empty", payload: []byte{}, err: true},
+	})
+}
+
+func TestDecodeFly(t *testing.T) {
+	runDecodeTests(t, DecodeFly, []decodeTest{
+		{name: "left", payload: []byte{DIR_LEFT, 0, 0}, keys: []string{"fly_left"}, values: []int64{0}},
+		{name: "right", payload: []byte{DIR_RIGHT, 0, 0}, keys: []string{"fly_right"}, values: []int64{0}},
+		{name: "backwards", payload: []byte{DIR_BACKWARDS, 0, 12}, keys: []string{"fly_backwards"}, values: []int64{12}},
+		{name: "towards", payload: []byte{DIR_TOWARDS, 0, 34}, keys: []string{"fly_towards"}, values: []int64{34}},
+		{name: "updown", payload: []byte{DIR_UPDOWN, 0, 250}, keys: []string{"fly_updown"}, values: []int64{250}},
+		{name: "short", payload: []byte{DIR_UPDOWN, 0}, err: true},
+		{name: "empty", payload: []byte{}, err: true},
+		{name: "unknown", payload: []byte{9, 0, 0}, err: true},
+	})
+}
+
+func TestDecodeBattery(t *testing.T) {
+	runDecodeTests(t, DecodeBattery, []decodeTest{
+		{name: "full", payload: []byte{100}, keys: []string{"battery"}, values: []int64{100}},
+		{name: "empty battery", payload: []byte{0}, keys: []string{"battery"}, values: []int64{0}},
+		{name: "empty", payload: []byte{}, err: true},
+	})
+}
@@ -0,0 +1,156 @@
+package nuimo
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/currantlabs/ble"
+)
+
+// FakeClient is a GattClient which offers the services of a Nuimo without Bluetooth. It
+// records the writes to the LED matrix and lets notifications be fired by hand, e.g.
+//
+//	fake := nuimo.NewFakeClient()
+//	n, _ := nuimo.ConnectWith(fake.Discover, time.Minute)
+//	fake.Notify(nuimo.CHAR_INPUT_SWIPE, []byte{nuimo.DIR_LEFT})
+type FakeClient struct {
+	mu          sync.Mutex
+	profile     *ble.Profile
+	handlers    map[string]ble.NotificationHandler
+	writes      [][]byte
+	battery     []byte
+	readErr     error
+	discoverErr error
+}
+
+type fakeAddr string
+
+func (a fakeAddr) String() string { return string(a) }
+
+// NewFakeClient creates a fake Nuimo with a battery level of 100%
+func NewFakeClient() *FakeClient {
+	service := func(uuid string, chars ...string) *ble.Service {
+		s := &ble.Service{UUID: ble.MustParse(uuid)}
+		for _, c := range chars {
+			s.Characteristics = append(s.Characteristics, &ble.Characteristic{UUID: ble.MustParse(c)})
+		}
+		return s
+	}
+	return &FakeClient{
+		profile: &ble.Profile{Services: []*ble.Service{
+			service(SERVICE_BATTERY_STATUS, CHAR_BATTERY_LEVEL),
+			service(SERVICE_DEVICE_INFO, CHAR_DEVICE_INFO),
+			service(SERVICE_LED_MATRIX, CHAR_LED_MATRIX),
+			service(SERVICE_USER_INPUT, CHAR_INPUT_FLY, CHAR_INPUT_SWIPE, CHAR_INPUT_ROTATE, CHAR_INPUT_CLICK),
+		}},
+		handlers: make(map[string]ble.NotificationHandler),
+		battery:  []byte{100},
+	}
+}
+
+// Discover can be passed to ConnectWith
+func (f *FakeClient) Discover() (GattClient, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.discoverErr != nil {
+		return nil, f.discoverErr
+	}
+	return f, nil
+}
+
+// SetBattery sets the level returned by battery reads
+func (f *FakeClient) SetBattery(level byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.battery = []byte{level}
+}
+
+// FailReads makes battery reads fail with err, nil makes them succeed again
+func (f *FakeClient) FailReads(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.readErr = err
+}
+
+// FailDiscovery makes Discover fail with err, nil makes it succeed again
+func (f *FakeClient) FailDiscovery(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.discoverErr = err
+}
+
+// Notify fires a notification of the given characteristic, it fails when nobody subscribed to it
+func (f *FakeClient) Notify(char string, req []byte) error {
+	f.mu.Lock()
+	h, present := f.handlers[strings.ToUpper(char)]
+	f.mu.Unlock()
+	if !present {
+		return errors.New("not subscribed to " + char)
+	}
+	h(req)
+	return nil
+}
+
+// Subscribed returns true when there is a subscription for the characteristic
+func (f *FakeClient) Subscribed(char string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, present := f.handlers[strings.ToUpper(char)]
+	return present
+}
+
+// Writes returns all values written to the LED matrix
+func (f *FakeClient) Writes() [][]byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]byte{}, f.writes...)
+}
+
+func (f *FakeClient) Address() ble.Addr {
+	return fakeAddr("fake-nuimo")
+}
+
+func (f *FakeClient) DiscoverProfile(force bool) (*ble.Profile, error) {
+	return f.profile, nil
+}
+
+func (f *FakeClient) ReadCharacteristic(c *ble.Characteristic) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !c.UUID.Equal(ble.MustParse(CHAR_BATTERY_LEVEL)) {
+		return nil, errors.New("characteristic not readable")
+	}
+	if f.readErr != nil {
+		return nil, f.readErr
+	}
+	return f.battery, nil
+}
+
+func (f *FakeClient) WriteCharacteristic(c *ble.Characteristic, value []byte, noRsp bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !c.UUID.Equal(ble.MustParse(CHAR_LED_MATRIX)) {
+		return errors.New("characteristic not writable")
+	}
+	f.writes = append(f.writes, append([]byte{}, value...))
+	return nil
+}
+
+func (f *FakeClient) Subscribe(c *ble.Characteristic, ind bool, h ble.NotificationHandler) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[strings.ToUpper(c.UUID.String())] = h
+	return nil
+}
+
+func (f *FakeClient) ClearSubscriptions() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers = make(map[string]ble.NotificationHandler)
+	return nil
+}
+
+func (f *FakeClient) CancelConnection() error {
+	return nil
+}
@@ -0,0 +1,155 @@
+package nuimo
+
+import (
+	"testing"
+	"time"
+)
+
+// next returns the next event other than the connection events
+func next(t *testing.T, n *Nuimo) Event {
+	for {
+		select {
+		case e := <-n.Events():
+			if e.Key == "connected" || e.Key == "disconnected" {
+				continue
+			}
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("no event received")
+		}
+	}
+}
+
+func TestFakeSubscriptions(t *testing.T) {
+	fake := NewFakeClient()
+	n, err := ConnectWith(fake.Discover, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Disconnect()
+
+	for _, char := range []string{CHAR_BATTERY_LEVEL, CHAR_DEVICE_INFO, CHAR_INPUT_CLICK, CHAR_INPUT_ROTATE, CHAR_INPUT_SWIPE, CHAR_INPUT_FLY} {
+		if !fake.Subscribed(char) {
+			t.Errorf("expected a subscription for %s", char)
+		}
+	}
+	if fake.Subscribed(CHAR_LED_MATRIX) {
+		t.Error("expected no subscription for the LED matrix")
+	}
+
+	notifications := []struct {
+		char    string
+		payload []byte
+		key     string
+		value   int64
+	}{
+		{CHAR_INPUT_CLICK, []byte{CLICK_DOWN}, "press", 0},
+		{CHAR_INPUT_ROTATE, []byte{0x20, 0x00}, "rotate", 32},
+		{CHAR_INPUT_CLICK, []byte{CLICK_UP}, "release", 0},
+		{CHAR_INPUT_FLY, []byte{DIR_UPDOWN, 0, 80}, "fly_updown", 80},
+		{CHAR_BATTERY_LEVEL, []byte{55}, "battery", 55},
+	}
+	var seq uint64
+	for _, notification := range notifications {
+		if err := fake.Notify(notification.char, notification.payload); err != nil {
+			t.Fatal(err)
+		}
+		e := next(t, n)
+		if e.Key != notification.key || e.Value != notification.value {
+			t.Errorf("expected %s=%d, got %s=%d", notification.key, notification.value, e.Key, e.Value)
+		}
+		if e.Seq <= seq {
+			t.Errorf("expected increasing sequence numbers, got %d after %d", e.Seq, seq)
+		}
+		seq = e.Seq
+		if e.Device != "fake-nuimo" {
+			t.Errorf("unexpected device %q", e.Device)
+		}
+	}
+
+	// invalid payloads are dropped
+	fake.Notify(CHAR_INPUT_CLICK, []byte{})
+	fake.Notify(CHAR_INPUT_CLICK, []byte{CLICK_DOWN})
+	if e := next(t, n); e.Key != "press" {
+		t.Errorf("expected the invalid notification to be dropped, got %s", e.Key)
+	}
+
+	n.Disconnect()
+	if fake.Subscribed(CHAR_INPUT_CLICK) {
+		t.Error("expected the subscriptions to be cleared on Disconnect")
+	}
+	if err := fake.Notify(CHAR_INPUT_CLICK, []byte{CLICK_DOWN}); err == nil {
+		t.Error("expected notifications to fail after Disconnect")
+	}
+}
+
+func TestFakeDisplay(t *testing.T) {
+	fake := NewFakeClient()
+	n, err := ConnectWith(fake.Discover, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matrix := DisplayMatrix(
+		1, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 1,
+	)
+	n.Display(matrix, 200, 10)
+
+	writes := fake.Writes()
+	if len(writes) != 1 {
+		t.Fatalf("expected a single write, got %d", len(writes))
+	}
+	w := writes[0]
+	if len(w) != 13 {
+		t.Fatalf("expected 13 bytes, got %d", len(w))
+	}
+	if w[0] != 1 || w[10] != 1 {
+		t.Errorf("expected the first and the last dot to be set, got %x", w[:11])
+	}
+	if w[11] != 200 || w[12] != 10 {
+		t.Errorf("expected brightness 200 and timeout 10, got %d and %d", w[11], w[12])
+	}
+
+	n.Disconnect()
+	n.Display(matrix, 200, 10)
+	if len(fake.Writes()) != 1 {
+		t.Error("expected no write without a connection")
+	}
+}
+
+func TestFakeBatteryRead(t *testing.T) {
+	fake := NewFakeClient()
+	fake.SetBattery(42)
+	n, err := ConnectWith(fake.Discover, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Disconnect()
+
+	e := next(t, n)
+	if e.Key != "battery" || e.Value != 42 {
+		t.Errorf("expected the keepalive to read battery=42, got %s=%d", e.Key, e.Value)
+	}
+
+	fake.SetBattery(41)
+	for {
+		e = next(t, n)
+		if e.Value != 42 {
+			break
+		}
+	}
+	if e.Key != "battery" || e.Value != 41 {
+		t.Errorf("expected battery=41, got %s=%d", e.Key, e.Value)
+	}
+	if n.State() != Subscribed {
+		t.Errorf("expected the connection to stay subscribed, got %s", n.State())
+	}
+}
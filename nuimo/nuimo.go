@@ -2,9 +2,9 @@
 package nuimo
 
 import (
-	"encoding/binary"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/currantlabs/ble"
@@ -35,43 +35,101 @@ const DIR_DOWN = 3
 const DIR_TOWARDS = 3
 const DIR_UPDOWN = 4
 
+// touch values reported on the swipe characteristic by newer firmware
+const TOUCH_LEFT = 4
+const TOUCH_RIGHT = 5
+const TOUCH_TOP = 6
+const TOUCH_BOTTOM = 7
+const LONGTOUCH_LEFT = 8
+const LONGTOUCH_RIGHT = 9
+const LONGTOUCH_TOP = 10
+const LONGTOUCH_BOTTOM = 11
+
 const CLICK_DOWN = 1
 const CLICK_UP = 0
 
+// EVENT_BUFFER is the number of events kept for a slow consumer, older events are dropped beyond
+const EVENT_BUFFER = 100
+
 var logger = log.New("nuimo")
 
+// GattClient is the part of ble.Client the Nuimo needs
+type GattClient interface {
+	Address() ble.Addr
+	DiscoverProfile(force bool) (*ble.Profile, error)
+	ReadCharacteristic(c *ble.Characteristic) ([]byte, error)
+	WriteCharacteristic(c *ble.Characteristic, value []byte, noRsp bool) error
+	Subscribe(c *ble.Characteristic, ind bool, h ble.NotificationHandler) error
+	ClearSubscriptions() error
+	CancelConnection() error
+}
+
 type Nuimo struct {
-	client ble.Client
-	events chan Event
-	led    *ble.Characteristic
-	bttry  *ble.Characteristic
+	events   chan Event
+	states   chan State
+	discover func() (GattClient, error)
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mu          sync.Mutex
+	client      GattClient
+	led         *ble.Characteristic
+	bttry       *ble.Characteristic
+	state       State
+	orientation Orientation
+	device      string
+	seq         uint64
+	dropped     uint64
+}
+
+func newNuimo(discover func() (GattClient, error)) *Nuimo {
+	return &Nuimo{
+		events:   make(chan Event, EVENT_BUFFER),
+		states:   make(chan State, STATE_BUFFER),
+		discover: discover,
+		stop:     make(chan struct{}),
+	}
 }
 
+// Event is a user input or status change of the device. Events are delivered in the order
+// they were received and numbered by Seq, a gap in the numbers means events were dropped.
 type Event struct {
-	Key   string
-	Value int64
-	Raw   []byte
+	Key    string
+	Value  int64
+	Raw    []byte
+	Time   time.Time
+	Seq    uint64
+	Device string
 }
 
-// Connect tried to find nearby devices and connects to them. It tries to reconnect when a timeout interval is passed as first argument.
+// Connect tries to find a nearby device and connects to it. The connection is supervised:
+// when it fails or gets lost the device is discovered again with an increasing backoff. The
+// connection is checked by reading the battery level every keepalive seconds, which can be
+// passed as first argument. The error of the first attempt is returned but the supervisor
+// keeps trying in the background.
 func Connect(params ...int) (*Nuimo, error) {
+	refresh := 0
+	if len(params) == 1 && params[0] > 0 {
+		refresh = params[0]
+	}
+	return ConnectWith(discoverDevice, time.Duration(refresh)*time.Second)
+}
 
-	ch := make(chan Event, 100)
-	n := &Nuimo{events: ch}
-	err := n.reconnect()
+// ConnectWith works like Connect but uses the given function to find the device, e.g. to
+// connect to a FakeClient.
+func ConnectWith(discover func() (GattClient, error), keepalive time.Duration) (*Nuimo, error) {
+	n := newNuimo(discover)
 
+	err := n.connect()
 	if err != nil {
-		logger.Fatal("%s", err)
-	}
-
-	if len(params) == 1 && params[0] > 0 {
-		go n.keepConnected(params[0])
+		logger.Error("Unable to connect", "err", err)
 	}
+	go n.supervise(keepalive)
 
 	return n, err
 }
 
-func discoverDevice() (ble.Client, error) {
+func discoverDevice() (GattClient, error) {
 	logger.Info("Discover")
 	filter := func(a ble.Advertisement) bool {
 		return strings.ToUpper(a.LocalName()) == "NUIMO"
@@ -95,59 +153,24 @@ func discoverDevice() (ble.Client, error) {
 				MinimumCELength:       0x0000,    // 0x0000 - 0xFFFF; N * 0.625 msec
 				MaximumCELength:       0x0000,    // 0x0000 - 0xFFFF; N * 0.625 msec
 			})); err != nil {
-			logger.Fatal("can't set advertising param: %s", err)
+			return nil, fmt.Errorf("can't set connection params: %s", err)
 		}
 	}
 	return gatt.Discover(gatt.FilterFunc(filter))
 }
 
-func (n *Nuimo) reconnect() error {
-	logger.Info("Reconnect")
-	if n.client != nil {
-		n.client.ClearSubscriptions()
-		n.client.CancelConnection()
-	}
-	client, err := discoverDevice()
-	if err != nil {
-		return err
-	}
-	n.client = client
-	return n.discoverServices()
-}
-
-func (n *Nuimo) keepConnected(refresh int) {
-
-	for {
-		c := make(chan []byte, 1)
-		go func() {
-			logger.Info("Reading batterie")
-			data, err := n.client.ReadCharacteristic(n.bttry)
-			if err != nil {
-				logger.Error("Error", err)
-				// this will cause a reconnect
-				return
-			}
-			c <- data
-
-		}()
-		select {
-		case data := <-c:
-			n.battery(data)
-		case <-time.After(30 * time.Second):
-			n.send(Event{Key: "disconnected"})
-			n.reconnect()
-		}
-		close(c)
-		time.Sleep(time.Duration(refresh) * time.Second)
-	}
-
-}
-
 // Events provides access to the events channel which contains the user interaction and battery level events
 func (n *Nuimo) Events() <-chan Event {
 	return n.events
 }
 
+// Dropped returns the number of events which were dropped because the events channel was full
+func (n *Nuimo) Dropped() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.dropped
+}
+
 // Display sends the passed byte atrix into the LED display of the Nuimo
 func (n *Nuimo) Display(matrix []byte, brightness uint8, timeout uint8) {
 
@@ -163,7 +186,17 @@ func (n *Nuimo) Display(matrix []byte, brightness uint8, timeout uint8) {
 	displayMatrix[11] = brightness
 	displayMatrix[12] = timeout
 
-	n.client.WriteCharacteristic(n.led, displayMatrix, true)
+	n.mu.Lock()
+	client, led := n.client, n.led
+	copy(displayMatrix, n.orientation.Matrix(displayMatrix[:11]))
+	n.mu.Unlock()
+	if client == nil || led == nil {
+		logger.Warn("Display not available", "state", n.State())
+		return
+	}
+	if err := client.WriteCharacteristic(led, displayMatrix, true); err != nil {
+		logger.Warn("Unable to write display", "err", err)
+	}
 }
 
 // DisplayMatrix transforms a matrix consisting of 0s and 1s into a byte matrix
@@ -186,11 +219,11 @@ func DisplayMatrix(dots ...byte) []byte {
 	return bytes
 }
 
-//  TODO: make sure we only subscribe to the services we need
-func (n *Nuimo) discoverServices() error {
-	p, err := n.client.DiscoverProfile(true)
+// TODO: make sure we only subscribe to the services we need
+func (n *Nuimo) discoverServices(client GattClient) (led *ble.Characteristic, bttry *ble.Characteristic, err error) {
+	p, err := client.DiscoverProfile(true)
 	if err != nil {
-		return fmt.Errorf("can't discover services: %s\n", err)
+		return nil, nil, fmt.Errorf("can't discover services: %s", err)
 	}
 
 	for _, s := range p.Services {
@@ -201,10 +234,10 @@ func (n *Nuimo) discoverServices() error {
 				switch {
 				case c.UUID.Equal(ble.MustParse(CHAR_DEVICE_INFO)):
 					logger.Info("Info subscribed")
-					n.client.Subscribe(c, false, n.info)
+					client.Subscribe(c, false, n.info)
 				default:
 					logger.Warn("Unknown device char", "uuid", c.UUID.String())
-					n.client.Subscribe(c, false, n.unknown)
+					client.Subscribe(c, false, n.handler(DecodeUnknown))
 				}
 			}
 		case s.UUID.Equal(ble.MustParse(SERVICE_BATTERY_STATUS)):
@@ -212,115 +245,81 @@ func (n *Nuimo) discoverServices() error {
 				switch {
 				case c.UUID.Equal(ble.MustParse(CHAR_BATTERY_LEVEL)):
 					logger.Info("Battery subscribed")
-					n.bttry = c
-					n.client.Subscribe(c, false, n.battery)
+					bttry = c
+					client.Subscribe(c, false, n.handler(DecodeBattery))
 
 				default:
 					logger.Warn("Unknown battery char", "uuid", c.UUID.String())
-					n.client.Subscribe(c, false, n.unknown)
+					client.Subscribe(c, false, n.handler(DecodeUnknown))
 				}
 			}
 		case s.UUID.Equal(ble.MustParse(SERVICE_USER_INPUT)):
 			for _, c := range s.Characteristics {
 				switch {
 				case c.UUID.Equal(ble.MustParse(CHAR_INPUT_CLICK)):
-					n.client.Subscribe(c, false, n.click)
+					client.Subscribe(c, false, n.handler(DecodeClick))
 				case c.UUID.Equal(ble.MustParse(CHAR_INPUT_ROTATE)):
-					n.client.Subscribe(c, false, n.rotate)
+					client.Subscribe(c, false, n.handler(DecodeRotate))
 				case c.UUID.Equal(ble.MustParse(CHAR_INPUT_SWIPE)):
-					n.client.Subscribe(c, false, n.swipe)
+					client.Subscribe(c, false, n.handler(DecodeSwipe))
 				case c.UUID.Equal(ble.MustParse(CHAR_INPUT_FLY)):
-					n.client.Subscribe(c, false, n.fly)
+					client.Subscribe(c, false, n.handler(DecodeFly))
 				default:
 					logger.Warn("Unknown input characteristik", "uuid", c.UUID.String())
-					n.client.Subscribe(c, false, n.unknown)
+					client.Subscribe(c, false, n.handler(DecodeUnknown))
 				}
 			}
 		case s.UUID.Equal(ble.MustParse(SERVICE_LED_MATRIX)):
 			for _, c := range s.Characteristics {
 				logger.Info("Led found")
-				n.led = c
+				led = c
 			}
 		default:
 			logger.Warn("Unknown service %s", "uuid", s.UUID.String())
 		}
 	}
-	n.send(Event{Key: "connected"})
-	return nil
+	if led == nil || bttry == nil {
+		return nil, nil, fmt.Errorf("device misses the LED matrix or battery characteristic")
+	}
+	return led, bttry, nil
 }
 
-// Disconnect closes the connection and drops all subscriptions
+// Disconnect stops the supervisor, closes the connection and drops all subscriptions
 func (n *Nuimo) Disconnect() error {
 	logger.Warn("Nuimo connection closed")
-	//close(n.events)
-	return n.client.CancelConnection()
+	n.stopOnce.Do(func() { close(n.stop) })
+	err := n.teardown()
+	n.setState(Disconnected)
+	return err
 }
 
-func (n *Nuimo) battery(req []byte) {
-	uval, _ := binary.Uvarint(req)
-	level := int64(uval)
-	n.send(Event{Key: "battery", Raw: req, Value: level})
-}
 func (n *Nuimo) info(req []byte) {
 	logger.Info("Info: " + string(req))
 }
 
-func (n *Nuimo) click(req []byte) {
-	uval, _ := binary.Uvarint(req)
-	dir := int64(uval)
-	switch dir {
-	case CLICK_DOWN:
-		n.send(Event{Key: "press", Raw: req})
-	case CLICK_UP:
-		n.send(Event{Key: "release", Raw: req})
-	}
-}
+// send stamps the event and queues it in order. Missing event sinks must not block the
+// client, so the oldest queued event is dropped when the buffer is full.
+func (n *Nuimo) send(e Event) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
 
-func (n *Nuimo) rotate(req []byte) {
-	uval := binary.LittleEndian.Uint16(req)
-	val := int64(int16(uval))
-	n.send(Event{Key: "rotate", Raw: req, Value: val})
-}
-func (n *Nuimo) swipe(req []byte) {
-	uval, _ := binary.Uvarint(req)
-	dir := int64(uval)
-	n.send(Event{Key: "swipe", Raw: req, Value: dir})
-
-	switch dir {
-	case DIR_LEFT:
-		n.send(Event{Key: "swipe_left", Raw: req})
-	case DIR_RIGHT:
-		n.send(Event{Key: "swipe_right", Raw: req})
-	case DIR_UP:
-		n.send(Event{Key: "swipe_up", Raw: req})
-	case DIR_DOWN:
-		n.send(Event{Key: "swipe_down", Raw: req})
-	}
-}
-func (n *Nuimo) fly(req []byte) {
-	uval, _ := binary.Uvarint(req[0:1])
-	dir := int(uval)
-	uval, _ = binary.Uvarint(req[2:])
-	distance := int64(uval)
-
-	switch dir {
-	case DIR_LEFT:
-		n.send(Event{Key: "fly_left", Raw: req, Value: distance})
-	case DIR_RIGHT:
-		n.send(Event{Key: "fly_right", Raw: req, Value: distance})
-	case DIR_BACKWARDS:
-		n.send(Event{Key: "fly_backwards", Raw: req, Value: distance})
-	case DIR_TOWARDS:
-		n.send(Event{Key: "fly_towards", Raw: req, Value: distance})
-	case DIR_UPDOWN:
-		n.send(Event{Key: "fly_updown", Raw: req, Value: distance})
-	}
-}
-func (n *Nuimo) unknown(req []byte) {
-	n.send(Event{Key: "unknown", Raw: req})
-}
+	e = n.orientation.Event(e)
+	n.seq++
+	e.Seq = n.seq
+	e.Time = time.Now()
+	e.Device = n.device
 
-// make sure missing event sinks don't block the client
-func (n *Nuimo) send(e Event) {
-	go func() { n.events <- e }()
+	for {
+		select {
+		case n.events <- e:
+			return
+		default:
+		}
+		select {
+		case old := <-n.events:
+			n.dropped++
+			logger.Warn("Event buffer full, dropped event", "key", old.Key, "seq", old.Seq, "dropped", n.dropped)
+		default:
+		}
+	}
 }
@@ -0,0 +1,90 @@
+package nuimo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Orientation describes how the Nuimo is mounted. Events are remapped and display
+// matrices are turned so that directions and icons match what the user sees.
+type Orientation struct {
+	// Rotation is the angle in degrees the device is turned clockwise: 0, 90, 180 or 270
+	Rotation int
+	// Mirrored swaps left and right, e.g. when the device is seen through a mirror
+	Mirrored bool
+	// InvertRotation turns clockwise rotations into counter-clockwise ones and vice versa
+	InvertRotation bool
+}
+
+// directions of the gestures in clockwise order starting at the top
+var directions = map[string][]string{
+	"swipe_":     {"up", "right", "down", "left"},
+	"touch_":     {"top", "right", "bottom", "left"},
+	"longtouch_": {"top", "right", "bottom", "left"},
+	"fly_":       {"backwards", "right", "towards", "left"},
+}
+
+// SetOrientation sets how the device is mounted
+func (n *Nuimo) SetOrientation(o Orientation) error {
+	if o.Rotation%90 != 0 || o.Rotation < 0 || o.Rotation >= 360 {
+		return fmt.Errorf("invalid rotation %d, use 0, 90, 180 or 270", o.Rotation)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.orientation = o
+	return nil
+}
+
+// Event maps the direction of a gesture on the device to the direction the user sees
+func (o Orientation) Event(e Event) Event {
+	if e.Key == "rotate" && o.InvertRotation {
+		e.Value = -e.Value
+	}
+	for prefix, names := range directions {
+		if !strings.HasPrefix(e.Key, prefix) {
+			continue
+		}
+		for idx, name := range names {
+			if e.Key != prefix+name {
+				continue
+			}
+			idx = (idx + o.Rotation/90) % 4
+			if o.Mirrored && idx%2 == 1 {
+				idx = 4 - idx
+			}
+			e.Key = prefix + names[idx]
+			return e
+		}
+	}
+	return e
+}
+
+// Matrix turns a matrix created by DisplayMatrix so that it is upright for the user
+func (o Orientation) Matrix(matrix []byte) []byte {
+	if o.Rotation == 0 && !o.Mirrored {
+		return matrix
+	}
+
+	var dots [81]byte
+	for dot := range dots {
+		if dot/8 < len(matrix) && matrix[dot/8]&(byte(1)<<uint(dot%8)) > 0 {
+			dots[dot] = 1
+		}
+	}
+
+	turned := make([]byte, 81)
+	for row := 0; row < 9; row++ {
+		for col := 0; col < 9; col++ {
+			r, c := row, col
+			if o.Mirrored {
+				c = 8 - c
+			}
+			// the device is turned clockwise, so the picture is turned back counter-clockwise
+			for i := 0; i < o.Rotation/90; i++ {
+				r, c = 8-c, r
+			}
+			turned[r*9+c] = dots[row*9+col]
+		}
+	}
+	return DisplayMatrix(turned...)
+}
@@ -0,0 +1,96 @@
+package nuimo
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestOrientationEvent(t *testing.T) {
+	tests := []struct {
+		orientation Orientation
+		key         string
+		want        string
+	}{
+		{Orientation{}, "swipe_up", "swipe_up"},
+		{Orientation{Rotation: 90}, "swipe_up", "swipe_right"},
+		{Orientation{Rotation: 90}, "swipe_left", "swipe_up"},
+		{Orientation{Rotation: 90}, "touch_top", "touch_right"},
+		{Orientation{Rotation: 90}, "fly_backwards", "fly_right"},
+		{Orientation{Rotation: 180}, "swipe_up", "swipe_down"},
+		{Orientation{Rotation: 180}, "longtouch_left", "longtouch_right"},
+		{Orientation{Rotation: 270}, "swipe_up", "swipe_left"},
+		{Orientation{Rotation: 270}, "touch_bottom", "touch_right"},
+		{Orientation{Mirrored: true}, "swipe_left", "swipe_right"},
+		{Orientation{Mirrored: true}, "swipe_up", "swipe_up"},
+		{Orientation{Mirrored: true}, "fly_towards", "fly_towards"},
+		{Orientation{Rotation: 90, Mirrored: true}, "swipe_up", "swipe_left"},
+		{Orientation{Rotation: 90, Mirrored: true}, "swipe_right", "swipe_down"},
+		{Orientation{Rotation: 270, Mirrored: true}, "touch_top", "touch_right"},
+		{Orientation{Rotation: 90}, "press", "press"},
+		{Orientation{Rotation: 90}, "swipe", "swipe"},
+	}
+	for _, test := range tests {
+		if got := test.orientation.Event(Event{Key: test.key}).Key; got != test.want {
+			t.Errorf("%+v: expected %s to become %s, got %s", test.orientation, test.key, test.want, got)
+		}
+	}
+}
+
+func TestOrientationRotate(t *testing.T) {
+	tests := []struct {
+		orientation Orientation
+		value       int64
+		want        int64
+	}{
+		{Orientation{}, 20, 20},
+		{Orientation{Rotation: 180, Mirrored: true}, 20, 20},
+		{Orientation{InvertRotation: true}, 20, -20},
+		{Orientation{InvertRotation: true}, -15, 15},
+	}
+	for _, test := range tests {
+		if got := test.orientation.Event(Event{Key: "rotate", Value: test.value}); got.Key != "rotate" || got.Value != test.want {
+			t.Errorf("%+v: expected rotate %d, got %s %d", test.orientation, test.want, got.Key, got.Value)
+		}
+	}
+}
+
+// dot returns a matrix with a single dot
+func dot(row, col int) []byte {
+	dots := make([]byte, 81)
+	dots[row*9+col] = 1
+	return DisplayMatrix(dots...)
+}
+
+func TestOrientationMatrix(t *testing.T) {
+	// the dot next to the top left corner tells turns and mirroring apart
+	tests := []struct {
+		orientation Orientation
+		row, col    int
+	}{
+		{Orientation{}, 0, 1},
+		{Orientation{Rotation: 90}, 7, 0},
+		{Orientation{Rotation: 180}, 8, 7},
+		{Orientation{Rotation: 270}, 1, 8},
+		{Orientation{Mirrored: true}, 0, 7},
+		{Orientation{Rotation: 90, Mirrored: true}, 1, 0},
+		{Orientation{Rotation: 180, Mirrored: true}, 8, 1},
+		{Orientation{Rotation: 270, Mirrored: true}, 7, 8},
+	}
+	for _, test := range tests {
+		if got := test.orientation.Matrix(dot(0, 1)); !bytes.Equal(got, dot(test.row, test.col)) {
+			t.Errorf("%+v: expected the dot at %d,%d, got %v", test.orientation, test.row, test.col, got)
+		}
+	}
+}
+
+func TestSetOrientation(t *testing.T) {
+	n := newNuimo(nil)
+	for _, rotation := range []int{-90, 45, 360} {
+		if err := n.SetOrientation(Orientation{Rotation: rotation}); err == nil {
+			t.Errorf("expected rotation %d to be rejected", rotation)
+		}
+	}
+	if err := n.SetOrientation(Orientation{Rotation: 270, Mirrored: true}); err != nil {
+		t.Error(err)
+	}
+}
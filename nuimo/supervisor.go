@@ -0,0 +1,237 @@
+package nuimo
+
+import (
+	"errors"
+	"time"
+)
+
+// State is the state of the connection to the Nuimo
+type State int
+
+const (
+	// Disconnected means there is no connection, the supervisor retries after the backoff
+	Disconnected State = iota
+	// Discovering means the supervisor scans for a device
+	Discovering
+	// Connecting means a device was found and its services are discovered
+	Connecting
+	// Subscribed means the device is connected and sends its events
+	Subscribed
+	// Degraded means the last keepalive failed, the connection is given up when the next one fails as well
+	Degraded
+)
+
+var stateNames = []string{"disconnected", "discovering", "connecting", "subscribed", "degraded"}
+
+func (s State) String() string {
+	if int(s) < len(stateNames) {
+		return stateNames[s]
+	}
+	return "unknown"
+}
+
+// STATE_BUFFER is the number of state changes kept for a slow consumer
+const STATE_BUFFER = 16
+
+// The timings of the supervisor are variables so that tests can shorten them
+var (
+	MIN_BACKOFF = time.Second
+	MAX_BACKOFF = time.Minute
+
+	// DEGRADED_RETRY is the time until the keepalive is retried after it failed once
+	DEGRADED_RETRY = 5 * time.Second
+
+	// READ_TIMEOUT is the time a keepalive waits for the battery level
+	READ_TIMEOUT = 30 * time.Second
+)
+
+// State returns the current connection state
+func (n *Nuimo) State() State {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.state
+}
+
+// States provides the changes of the connection state. When nobody reads them the
+// oldest changes are dropped.
+func (n *Nuimo) States() <-chan State {
+	return n.states
+}
+
+func (n *Nuimo) setState(s State) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.setStateLocked(s)
+}
+
+// setStateLocked changes the state while n.mu is held
+func (n *Nuimo) setStateLocked(s State) {
+	if n.state == s {
+		return
+	}
+	logger.Info("Connection state", "from", n.state, "to", s)
+	n.state = s
+
+	for {
+		select {
+		case n.states <- s:
+			return
+		default:
+		}
+		select {
+		case <-n.states:
+		default:
+		}
+	}
+}
+
+// connect discovers the device and subscribes to its services
+func (n *Nuimo) connect() error {
+	n.setState(Discovering)
+	client, err := n.discover()
+	if err != nil {
+		n.setState(Disconnected)
+		return err
+	}
+
+	n.setState(Connecting)
+	led, bttry, err := n.discoverServices(client)
+	if err != nil {
+		client.ClearSubscriptions()
+		client.CancelConnection()
+		n.setState(Disconnected)
+		return err
+	}
+
+	// the stop check and the subscription are done under the lock, so a concurrent
+	// Disconnect either prevents the connection or tears it down afterwards
+	n.mu.Lock()
+	select {
+	case <-n.stop:
+		n.mu.Unlock()
+		client.ClearSubscriptions()
+		client.CancelConnection()
+		n.setState(Disconnected)
+		return errors.New("disconnected while connecting")
+	default:
+	}
+	n.client, n.led, n.bttry = client, led, bttry
+	n.device = client.Address().String()
+	n.setStateLocked(Subscribed)
+	n.mu.Unlock()
+
+	n.send(Event{Key: "connected"})
+	return nil
+}
+
+// teardown drops the subscriptions and closes the connection
+func (n *Nuimo) teardown() error {
+	n.mu.Lock()
+	client := n.client
+	n.client, n.led, n.bttry = nil, nil, nil
+	n.mu.Unlock()
+
+	if client == nil {
+		return nil
+	}
+	client.ClearSubscriptions()
+	return client.CancelConnection()
+}
+
+// supervise reconnects with an exponential backoff and checks the connection every refresh
+// interval. Without a refresh interval an established connection is not checked.
+func (n *Nuimo) supervise(refresh time.Duration) {
+	backoff := MIN_BACKOFF
+	for {
+		switch n.State() {
+		case Disconnected:
+			if !n.sleep(backoff) {
+				return
+			}
+			if err := n.connect(); err != nil {
+				backoff *= 2
+				if backoff > MAX_BACKOFF {
+					backoff = MAX_BACKOFF
+				}
+				logger.Warn("Unable to connect", "err", err, "retry", backoff)
+				continue
+			}
+			backoff = MIN_BACKOFF
+		case Degraded:
+			if !n.sleep(DEGRADED_RETRY) {
+				return
+			}
+			n.keepalive()
+		default:
+			if refresh <= 0 {
+				<-n.stop
+				return
+			}
+			if !n.sleep(refresh) {
+				return
+			}
+			n.keepalive()
+		}
+	}
+}
+
+// keepalive reads the battery level. The first failure degrades the connection, the second
+// one gives it up.
+func (n *Nuimo) keepalive() {
+	logger.Info("Reading battery")
+	data, err := n.readBattery()
+	if err == nil {
+		n.handler(DecodeBattery)(data)
+		n.mu.Lock()
+		// Disconnect might have dropped the connection during the read
+		if n.client != nil {
+			n.setStateLocked(Subscribed)
+		}
+		n.mu.Unlock()
+		return
+	}
+
+	logger.Warn("Keepalive failed", "err", err)
+	if n.State() == Subscribed {
+		n.setState(Degraded)
+		return
+	}
+	n.send(Event{Key: "disconnected"})
+	n.teardown()
+	n.setState(Disconnected)
+}
+
+func (n *Nuimo) readBattery() ([]byte, error) {
+	n.mu.Lock()
+	client, bttry := n.client, n.bttry
+	n.mu.Unlock()
+	if client == nil || bttry == nil {
+		return nil, errors.New("not connected")
+	}
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	c := make(chan result, 1)
+	go func() {
+		data, err := client.ReadCharacteristic(bttry)
+		c <- result{data, err}
+	}()
+	select {
+	case r := <-c:
+		return r.data, r.err
+	case <-time.After(READ_TIMEOUT):
+		return nil, errors.New("timeout reading the battery level")
+	}
+}
+
+// sleep waits for the given time and returns false when the supervisor was stopped meanwhile
+func (n *Nuimo) sleep(d time.Duration) bool {
+	select {
+	case <-n.stop:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
@@ -0,0 +1,221 @@
+package nuimo
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMain shortens the timings of the supervisor for all tests. They are not changed by
+// single tests as the supervisors of previous tests might still read them.
+func TestMain(m *testing.M) {
+	MIN_BACKOFF, MAX_BACKOFF = 20*time.Millisecond, 160*time.Millisecond
+	DEGRADED_RETRY, READ_TIMEOUT = 10*time.Millisecond, 100*time.Millisecond
+	os.Exit(m.Run())
+}
+
+// attempts records when the supervisor tried to discover the device
+type attempts struct {
+	mu    sync.Mutex
+	times []time.Time
+}
+
+func (a *attempts) discover(fake *FakeClient) func() (GattClient, error) {
+	return func() (GattClient, error) {
+		a.mu.Lock()
+		a.times = append(a.times, time.Now())
+		a.mu.Unlock()
+		return fake.Discover()
+	}
+}
+
+func (a *attempts) count() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.times)
+}
+
+func (a *attempts) gaps() []time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var gaps []time.Duration
+	for i := 1; i < len(a.times); i++ {
+		gaps = append(gaps, a.times[i].Sub(a.times[i-1]))
+	}
+	return gaps
+}
+
+// collect reads the state changes until the channel is quiet for a while
+func collect(states <-chan State, quiet time.Duration) []State {
+	var seen []State
+	for {
+		select {
+		case s := <-states:
+			seen = append(seen, s)
+		case <-time.After(quiet):
+			return seen
+		}
+	}
+}
+
+// waitFor reads state changes until the wanted state shows up
+func waitFor(t *testing.T, states <-chan State, want State, seen *[]State) {
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case s := <-states:
+			*seen = append(*seen, s)
+			if s == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("state %s not reached, got %v", want, *seen)
+		}
+	}
+}
+
+// compact removes repetitions of a state, e.g. the retries while the device is not found
+func compact(states []State) []State {
+	var out []State
+	for _, s := range states {
+		if len(out) > 0 && out[len(out)-1] == s {
+			continue
+		}
+		if len(out) > 1 && out[len(out)-2] == s && s == Discovering {
+			out = out[:len(out)-1]
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func TestSupervisorReconnects(t *testing.T) {
+	fake := NewFakeClient()
+	var a attempts
+	n, err := ConnectWith(a.discover(fake), 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Disconnect()
+
+	var seen []State
+	waitFor(t, n.States(), Subscribed, &seen)
+
+	failure := errors.New("out of range")
+	fake.FailDiscovery(failure)
+	fake.FailReads(failure)
+	waitFor(t, n.States(), Disconnected, &seen)
+	for a.count() < 5 {
+		waitFor(t, n.States(), Discovering, &seen)
+	}
+
+	fake.FailDiscovery(nil)
+	fake.FailReads(nil)
+	waitFor(t, n.States(), Subscribed, &seen)
+
+	want := []State{Discovering, Connecting, Subscribed, Degraded, Disconnected, Discovering, Connecting, Subscribed}
+	got := compact(seen)
+	if len(got) != len(want) {
+		t.Fatalf("expected the states %v, got %v (%v)", want, got, seen)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected the states %v, got %v (%v)", want, got, seen)
+		}
+	}
+
+	// the first gap is the initial connect followed by the keepalives, the others are backoffs
+	gaps := a.gaps()[1:]
+	for i := 1; i < len(gaps) && gaps[i-1]*2 <= MAX_BACKOFF; i++ {
+		if gaps[i] < gaps[i-1]*3/2 {
+			t.Errorf("expected the backoff to grow, got %v", gaps)
+			break
+		}
+	}
+	if gaps[0] < MIN_BACKOFF {
+		t.Errorf("expected the first retry after %s, got %v", MIN_BACKOFF, gaps)
+	}
+
+	events := 0
+	for len(n.Events()) > 0 {
+		e := <-n.Events()
+		if e.Key == "connected" || e.Key == "disconnected" {
+			events++
+		}
+	}
+	if events != 3 {
+		t.Errorf("expected connected, disconnected and connected events, got %d", events)
+	}
+}
+
+func TestDisconnectStopsSupervisor(t *testing.T) {
+	fake := NewFakeClient()
+	fake.FailDiscovery(errors.New("out of range"))
+	var a attempts
+	n, err := ConnectWith(a.discover(fake), 20*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected the first connect to fail")
+	}
+
+	var seen []State
+	for a.count() < 3 {
+		waitFor(t, n.States(), Discovering, &seen)
+	}
+	n.Disconnect()
+	collect(n.States(), 10*time.Millisecond)
+	count := a.count()
+
+	fake.FailDiscovery(nil)
+	time.Sleep(3 * MAX_BACKOFF)
+	if a.count() != count {
+		t.Errorf("expected no discovery after Disconnect, got %d more", a.count()-count)
+	}
+	if n.State() != Disconnected {
+		t.Errorf("expected the state to stay disconnected, got %s", n.State())
+	}
+	if fake.Subscribed(CHAR_INPUT_CLICK) {
+		t.Error("expected no subscriptions after Disconnect")
+	}
+}
+
+func TestDisconnectWhileConnecting(t *testing.T) {
+	fake := NewFakeClient()
+	fake.FailDiscovery(errors.New("out of range"))
+	found, proceed := make(chan bool), make(chan bool)
+	var once sync.Once
+	n, err := ConnectWith(func() (GattClient, error) {
+		client, err := fake.Discover()
+		if err == nil {
+			once.Do(func() {
+				found <- true
+				<-proceed
+			})
+		}
+		return client, err
+	}, 20*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected the first connect to fail")
+	}
+
+	// the device shows up and is disconnected before it is subscribed
+	fake.FailDiscovery(nil)
+	<-found
+	n.Disconnect()
+	close(proceed)
+	collect(n.States(), 3*MAX_BACKOFF)
+
+	if n.State() != Disconnected {
+		t.Errorf("expected the state to stay disconnected, got %s", n.State())
+	}
+	if fake.Subscribed(CHAR_INPUT_CLICK) {
+		t.Error("expected no subscriptions after Disconnect")
+	}
+	for len(n.Events()) > 0 {
+		if e := <-n.Events(); e.Key == "connected" {
+			t.Error("expected no connected event after Disconnect")
+		}
+	}
+}
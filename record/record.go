@@ -0,0 +1,76 @@
+// Package record writes Nuimo events into JSON lines files and feeds them back later on.
+package record
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/mgutz/logxi/v1"
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+)
+
+var logger = log.New("record")
+
+// Entry is a single recorded event, one per line in the recording
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Key    string    `json:"key"`
+	Value  int64     `json:"value"`
+	Raw    []byte    `json:"raw,omitempty"`
+	Seq    uint64    `json:"seq,omitempty"`
+	Device string    `json:"device,omitempty"`
+}
+
+// Record passes all events through to the returned channel and writes each of them to w
+// together with the time the Nuimo event was received.
+// The returned channel is closed once the source channel is closed.
+func Record(events <-chan nuimo.Event, w io.Writer) <-chan nuimo.Event {
+	out := make(chan nuimo.Event, cap(events))
+	enc := json.NewEncoder(w)
+
+	go func() {
+		defer close(out)
+		for {
+			event, more := <-events
+			if !more {
+				return
+			}
+			entry := Entry{Time: event.Time, Key: event.Key, Value: event.Value, Raw: event.Raw, Seq: event.Seq, Device: event.Device}
+			if entry.Time.IsZero() {
+				entry.Time = time.Now()
+			}
+			if err := enc.Encode(entry); err != nil {
+				logger.Error("Unable to record event", "key", event.Key, "err", err)
+			}
+			out <- event
+		}
+	}()
+	return out
+}
+
+// Replay reads a recording from r and sends the events into the events channel.
+// The speed factor scales the recorded delays between events: 1 keeps the original
+// timing, 2 replays twice as fast and 0 sends all events without any delay.
+func Replay(r io.Reader, events chan<- nuimo.Event, speed float64) error {
+	dec := json.NewDecoder(r)
+	var last time.Time
+	for {
+		var entry Entry
+		err := dec.Decode(&entry)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if speed > 0 && !last.IsZero() && entry.Time.After(last) {
+			time.Sleep(time.Duration(float64(entry.Time.Sub(last)) / speed))
+		}
+		last = entry.Time
+
+		logger.Debug("Replay event", "key", entry.Key, "value", entry.Value)
+		events <- nuimo.Event{Key: entry.Key, Value: entry.Value, Raw: entry.Raw, Time: entry.Time, Seq: entry.Seq, Device: entry.Device}
+	}
+}
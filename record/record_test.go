@@ -0,0 +1,97 @@
+package record
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tolleiv/nuimo-fhem/fhem"
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+	"github.com/tolleiv/nuimo-fhem/scenes"
+)
+
+const config = `
+scenes:
+  light:
+    press: "fhem: set lamp toggle"
+    rotate_right: "fhem: set lamp dim {{.Value}}"
+    swipe_up: "fhem: set lamp on"
+`
+
+func TestRecordAndReplay(t *testing.T) {
+	start := time.Date(2017, 3, 1, 20, 0, 0, 0, time.UTC)
+	session := []nuimo.Event{
+		{Key: "press", Value: 1, Raw: []byte{1}, Seq: 1, Time: start},
+		{Key: "release", Value: 0, Raw: []byte{0}, Seq: 2, Time: start.Add(200 * time.Millisecond)},
+		{Key: "rotate", Value: 40, Raw: []byte{40, 0}, Seq: 3, Time: start.Add(time.Second)},
+		{Key: "swipe_up", Value: 0, Raw: []byte{2}, Seq: 4, Time: start.Add(2 * time.Second)},
+	}
+
+	// recording passes the events through unchanged
+	events := make(chan nuimo.Event, len(session))
+	for _, e := range session {
+		events <- e
+	}
+	close(events)
+	recording := new(bytes.Buffer)
+	var passed []nuimo.Event
+	for e := range Record(events, recording) {
+		passed = append(passed, e)
+	}
+	if len(passed) != len(session) || passed[2].Key != "rotate" || passed[2].Value != 40 {
+		t.Fatalf("unexpected events passed through %v", passed)
+	}
+	if lines := strings.Count(recording.String(), "\n"); lines != len(session) {
+		t.Fatalf("expected %d recorded lines, got %d", len(session), lines)
+	}
+
+	viper.Reset()
+	viper.SetConfigType("yaml")
+	if err := viper.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+	c := scenes.NewController()
+	trace := new(bytes.Buffer)
+	c.TraceCommands("fhem", trace)
+	if err := c.Handle("fhem", &fhem.DryRun{}, scenes.HandlerOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	replayed := make(chan nuimo.Event)
+	done := make(chan bool)
+	go func() {
+		c.Listen(context.Background(), replayed)
+		done <- true
+	}()
+
+	// without delays the recorded two seconds are replayed at once
+	begin := time.Now()
+	if err := Replay(recording, replayed, 0); err != nil {
+		t.Fatal(err)
+	}
+	close(replayed)
+	<-done
+	if d := time.Since(begin); d > time.Second {
+		t.Errorf("expected the replay without delays, took %s", d)
+	}
+	if !c.Wait(time.Second) {
+		t.Fatal("commands still running")
+	}
+
+	var commands []string
+	for _, line := range strings.Split(strings.TrimSpace(trace.String()), "\n") {
+		command := strings.SplitN(line, " (", 2)[0]
+		commands = append(commands, strings.Join(strings.Fields(command), " "))
+	}
+	want := []string{"fhem: set lamp toggle", "fhem: set lamp dim 40", "fhem: set lamp on"}
+	if strings.Join(commands, "|") != strings.Join(want, "|") {
+		t.Errorf("expected the commands %v, got %v", want, commands)
+	}
+
+	events = make(chan nuimo.Event, len(session))
+	if err := Replay(strings.NewReader(`{"key": "press"`), events, 0); err == nil {
+		t.Error("expected a broken recording to fail")
+	}
+}
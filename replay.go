@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/tolleiv/nuimo-fhem/fhem"
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+	"github.com/tolleiv/nuimo-fhem/record"
+	"github.com/tolleiv/nuimo-fhem/scenes"
+)
+
+// replay feeds a recorded session through the scene controller and prints the produced commands.
+// FHEM commands are only sent to the server when the -fhem flag is given.
+func replay(args []string, fhemAddress string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	speed := flags.Float64("speed", 1, "Replay speed factor, 0 replays all events without delay")
+	useFhem := flags.Bool("fhem", false, "Send the replayed commands to the FHEM server")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: main [options] replay [-speed factor] [-fhem] file.jsonl")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		logger.Fatal("Unable to open recording", "file", flags.Arg(0), "err", err)
+	}
+	defer file.Close()
+
+	c := scenes.NewController()
+
+	// only FHEM commands may be sent, all other handles are just printed
+	for _, name := range append([]string{"fhem", "nuimo"}, scenes.Registered()...) {
+		c.TraceCommands(name, os.Stdout)
+	}
+
+	var f fhem.Client = &fhem.DryRun{}
+	if *useFhem {
+		f = &fhem.Fhem{Address: fhemAddress}
+	}
+	if err := c.Handle("fhem", f, scenes.HandlerOptions{}); err != nil {
+		logger.Fatal("Invalid handler configuration", "err", err)
+	}
+
+	ctx := context.Background()
+	events := make(chan nuimo.Event)
+	done := make(chan bool)
+	go func() {
+		c.Listen(ctx, events)
+		done <- true
+	}()
+
+	err = record.Replay(file, events, *speed)
+	close(events)
+	<-done
+	if err != nil {
+		logger.Fatal("Unable to replay recording", "err", err)
+	}
+
+	c.Wait(5 * time.Second)
+}
@@ -0,0 +1,94 @@
+package scenes
+
+import (
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+)
+
+const batteryHistorySize = 100
+
+// BatteryEntry is a reported battery level
+type BatteryEntry struct {
+	Time  time.Time `json:"time"`
+	Level int64     `json:"level"`
+}
+
+// battery tracks the reported levels and turns them into the states ok, low and critical.
+// A state is only left when the level rose by the hysteresis above its threshold, so a
+// level jumping around a threshold does not fire the events over and over.
+type battery struct {
+	low        int64
+	critical   int64
+	hysteresis int64
+	icon       string
+	state      string
+	history    []BatteryEntry
+	iconShown  time.Time
+}
+
+func newBattery() *battery {
+	return &battery{
+		low:        cast.ToInt64(setting("battery", "low", 20)),
+		critical:   cast.ToInt64(setting("battery", "critical", 10)),
+		hysteresis: cast.ToInt64(setting("battery", "hysteresis", 5)),
+		icon:       cast.ToString(setting("battery", "icon", "battery")),
+		state:      "ok",
+	}
+}
+
+// update records the level and returns the state when it changed
+func (b *battery) update(level int64, now time.Time) (string, bool) {
+	b.history = append(b.history, BatteryEntry{Time: now, Level: level})
+	if len(b.history) > batteryHistorySize {
+		b.history = b.history[len(b.history)-batteryHistorySize:]
+	}
+	return b.transition(level)
+}
+
+func (b *battery) transition(level int64) (string, bool) {
+	state := b.state
+	switch {
+	case level <= b.critical:
+		state = "critical"
+	case level <= b.low:
+		if b.state == "ok" || level >= b.critical+b.hysteresis {
+			state = "low"
+		}
+	case b.state == "critical" && level < b.critical+b.hysteresis:
+	case b.state != "ok" && level < b.low+b.hysteresis:
+		state = "low"
+	default:
+		state = "ok"
+	}
+
+	changed := state != b.state
+	b.state = state
+	return state, changed
+}
+
+// showIcon returns true once a day while the battery is low
+func (b *battery) showIcon(now time.Time) bool {
+	if b.state == "ok" || b.icon == "" || now.Sub(b.iconShown) < 24*time.Hour {
+		return false
+	}
+	b.iconShown = now
+	return true
+}
+
+// handleBattery has to be called with the lock held
+func (c *controller) handleBattery(event nuimo.Event) {
+	c.battery = event.Value
+	batteryLevel.Set(float64(event.Value))
+	c.dispatch(c.CurrentState(), "battery", event)
+
+	now := time.Now()
+	if state, changed := c.batteryState.update(event.Value, now); changed {
+		logger.Info("Battery state changed", "state", state, "level", event.Value)
+		c.dispatch(c.CurrentState(), "battery_"+state, nuimo.Event{Key: "battery_" + state, Value: event.Value})
+	}
+	if c.batteryState.showIcon(now) {
+		c.dispatchCommand(Command{Handle: "nuimo", Text: c.batteryState.icon, Scene: c.nullState.Name, Action: "battery_" + c.batteryState.state, Event: event})
+	}
+}
@@ -0,0 +1,79 @@
+package scenes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+)
+
+func TestBatteryHysteresis(t *testing.T) {
+	b := &battery{low: 20, critical: 10, hysteresis: 5, state: "ok"}
+	steps := []struct {
+		level   int64
+		state   string
+		changed bool
+	}{
+		{50, "ok", false},
+		{21, "ok", false},
+		{20, "low", true},
+		{24, "low", false},
+		{11, "low", false},
+		{10, "critical", true},
+		{14, "critical", false},
+		{15, "low", true},
+		{12, "low", false},
+		{9, "critical", true},
+		{24, "low", true},
+		{25, "ok", true},
+		{19, "low", true},
+		// a full charge leaves the critical state right away
+		{3, "critical", true},
+		{100, "ok", true},
+	}
+	for i, step := range steps {
+		state, changed := b.update(step.level, time.Now())
+		if state != step.state || changed != step.changed {
+			t.Errorf("step %d, level %d: expected %s (changed %v), got %s (changed %v)", i, step.level, step.state, step.changed, state, changed)
+		}
+	}
+	if len(b.history) != len(steps) {
+		t.Errorf("expected %d history entries, got %d", len(steps), len(b.history))
+	}
+}
+
+func TestBatteryPartlyConfigured(t *testing.T) {
+	newTestController(t, "battery:\n  low: 30\nscenes:\n  a: {}\n")
+	b := newBattery()
+	if b.low != 30 || b.critical != 10 || b.hysteresis != 5 || b.icon != "battery" {
+		t.Errorf("expected the defaults next to the configured level, got %+v", b)
+	}
+
+	newTestController(t, "battery:\n  hysteresis: 0\n  icon: ''\nscenes:\n  a: {}\n")
+	b = newBattery()
+	if b.low != 20 || b.hysteresis != 0 || b.icon != "" {
+		t.Errorf("expected the configured zero values, got %+v", b)
+	}
+}
+
+func TestBatteryEvents(t *testing.T) {
+	c, r := newRecordingController(t, `
+battery:
+  low: 30
+default:
+  battery_low: "fhem: low {{.Value}}"
+  battery_critical: "fhem: critical {{.Value}}"
+  battery_ok: "fhem: ok {{.Value}}"
+scenes:
+  a: {}
+`)
+	for _, level := range []int64{80, 30, 28, 10, 12, 33, 35} {
+		c.handleEvent(nuimo.Event{Key: "battery", Value: level})
+	}
+	if got := handled(t, c, r); got != "[low 30 critical 10 low 33 ok 35]" {
+		t.Errorf("unexpected commands %s", got)
+	}
+	if st := c.Status(); st.Battery != 35 || st.BatteryState != "ok" || len(st.BatteryHistory) != 7 {
+		t.Errorf("unexpected status %d %s %d", st.Battery, st.BatteryState, len(st.BatteryHistory))
+	}
+}
@@ -4,21 +4,34 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"html/template"
+	"math"
 	"strings"
+	"text/template"
 
-	"github.com/tolleiv/nuimo"
+	"github.com/spf13/cast"
+	"github.com/tolleiv/nuimo-fhem/nuimo"
 )
 
-type command struct {
-	handle  string
-	command string
-	Value   string
+// Command is a rendered scene action. It is passed on to the handler registered for its handle.
+type Command struct {
+	Handle   string
+	Text     string
+	Scene    string
+	Action   string
+	Template string
+	Event    nuimo.Event
 }
 
-func NewCommand(compound string, event nuimo.Event) (*command, error) {
+// TemplateData is available in command templates, e.g. {{.Value}} or {{.Vars.input}}
+type TemplateData struct {
+	nuimo.Event
+	Scene string
+	Vars  map[string]interface{}
+}
+
+func NewCommand(compound string, data TemplateData) (*Command, error) {
 	if strings.TrimSpace(compound) == "" {
-		return &command{handle: "empty", command: "", Value: ""}, nil
+		return &Command{Handle: "empty", Event: data.Event}, nil
 	}
 
 	parts := strings.SplitN(strings.TrimSpace(compound), ":", 2)
@@ -26,12 +39,45 @@ func NewCommand(compound string, event nuimo.Event) (*command, error) {
 		return nil, errors.New(fmt.Sprintf("Invalid command %s", compound))
 	}
 
-	tmpl, err := template.New("command").Parse(parts[1])
+	text, err := render(parts[1], data)
 	if err != nil {
 		return nil, err
 	}
-	buf := new(bytes.Buffer)
-	tmpl.Execute(buf, event)
 
-	return &command{handle: parts[0], command: buf.String(), Value: ""}, nil
+	return &Command{Handle: parts[0], Text: text, Template: compound, Event: data.Event}, nil
+}
+
+var funcs = template.FuncMap{
+	"scale": scale,
+}
+
+// scale maps the value from one range to another, e.g. {{scale .Value 0 250 0 100}} turns
+// a fly distance into a percentage. Values outside the range are clamped.
+func scale(value, fromMin, fromMax, toMin, toMax interface{}) (int64, error) {
+	var f [5]float64
+	for idx, arg := range []interface{}{value, fromMin, fromMax, toMin, toMax} {
+		n, err := cast.ToFloat64E(arg)
+		if err != nil {
+			return 0, err
+		}
+		f[idx] = n
+	}
+	if f[1] == f[2] {
+		return 0, errors.New("scale needs a range")
+	}
+	ratio := math.Max(0, math.Min(1, (f[0]-f[1])/(f[2]-f[1])))
+	return int64(math.Floor(f[3] + ratio*(f[4]-f[3]) + 0.5)), nil
+}
+
+// render fails for unknown variables instead of rendering "<no value>"
+func render(text string, data TemplateData) (string, error) {
+	tmpl, err := template.New("command").Option("missingkey=error").Funcs(funcs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
 }
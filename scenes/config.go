@@ -0,0 +1,13 @@
+package scenes
+
+import "github.com/spf13/viper"
+
+// setting returns the value of the key in a section of the configuration or the fallback.
+// viper.SetDefault can not be used for these, nested defaults get lost as soon as the
+// section is part of the configuration.
+func setting(section, key string, fallback interface{}) interface{} {
+	if value, present := viper.GetStringMap(section)[key]; present {
+		return value
+	}
+	return fallback
+}
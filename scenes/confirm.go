@@ -0,0 +1,137 @@
+package scenes
+
+import (
+	"strings"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+)
+
+// confirmation asks the user to repeat a gesture before an action is run
+type confirmation struct {
+	// gesture has to follow within the timeout, by default the gesture of the action itself
+	gesture string
+	timeout time.Duration
+}
+
+// newConfirmation reads "confirm: true" or a map with gesture and timeout, it returns nil
+// when no confirmation is needed
+func newConfirmation(value interface{}) (*confirmation, error) {
+	settings, err := cast.ToStringMapE(value)
+	if err != nil {
+		confirm, err := cast.ToBoolE(value)
+		if err != nil || !confirm {
+			return nil, err
+		}
+		return &confirmation{}, nil
+	}
+
+	conf := &confirmation{gesture: cast.ToString(settings["gesture"])}
+	if timeout, present := settings["timeout"]; present {
+		if conf.timeout, err = cast.ToDurationE(timeout); err != nil {
+			return nil, err
+		}
+	}
+	return conf, nil
+}
+
+// confirmSettings are the settings of the confirm section which apply to all confirmations
+type confirmSettings struct {
+	timeout  time.Duration
+	pause    time.Duration
+	question string
+	cancel   string
+}
+
+func newConfirmSettings() *confirmSettings {
+	return &confirmSettings{
+		timeout:  cast.ToDuration(setting("confirm", "timeout", "3s")),
+		pause:    cast.ToDuration(setting("confirm", "pause", "500ms")),
+		question: cast.ToString(setting("confirm", "question", "question")),
+		cancel:   cast.ToString(setting("confirm", "cancel", "cross")),
+	}
+}
+
+// question is an action waiting for its confirmation
+type question struct {
+	scene   *state
+	action  string
+	event   nuimo.Event
+	gesture string
+	// continuous is set for rotations and fly gestures which send notifications as long as
+	// they last, last is the time of the latest notification of the motion which asked
+	continuous bool
+	last       time.Time
+}
+
+// continuous returns true for gestures which are notified repeatedly during one motion
+func continuous(gesture string) bool {
+	return strings.HasPrefix(gesture, "rotate_") || strings.HasPrefix(gesture, "press+rotate_") || strings.HasPrefix(gesture, "fly_")
+}
+
+// ask shows the question icon and waits for the confirmation of the action, it has to be
+// called with the lock held
+func (c *controller) ask(s *state, name string, event nuimo.Event, conf *confirmation) {
+	q := &question{scene: s, action: name, event: event, gesture: conf.gesture, continuous: continuous(name), last: time.Now()}
+	if q.gesture == "" {
+		q.gesture = name
+	}
+	timeout := conf.timeout
+	if timeout == 0 {
+		timeout = c.confirm.timeout
+	}
+	c.question = q
+	logger.Info("Waiting for confirmation", "scene", s.Name, "action", name, "gesture", q.gesture)
+	c.showIcon(c.confirm.question, s, "confirm")
+
+	time.AfterFunc(timeout, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.question == q {
+			c.cancelQuestion()
+		}
+	})
+}
+
+// answer runs the action waiting for confirmation when the gesture matches and cancels it
+// otherwise. It returns false when there is no question or the event is neutral. The
+// notifications of a continuous gesture only count as answer after a pause, before they
+// belong to the motion which asked.
+func (c *controller) answer(gesture string) bool {
+	q := c.question
+	if q == nil || gesture == "" || (gesture == "release" && q.gesture != "release") {
+		return false
+	}
+	if q.continuous && gesture == q.action {
+		now := time.Now()
+		pause := now.Sub(q.last)
+		q.last = now
+		if pause < c.confirm.pause {
+			return true
+		}
+	}
+	if gesture != q.gesture {
+		c.cancelQuestion()
+		return true
+	}
+	logger.Info("Action confirmed", "scene", q.scene.Name, "action", q.action)
+	c.question = nil
+	c.dispatchAction(q.scene, q.action, q.event, true)
+	return true
+}
+
+func (c *controller) cancelQuestion() {
+	q := c.question
+	logger.Info("Action cancelled", "scene", q.scene.Name, "action", q.action)
+	c.question = nil
+	c.showIcon(c.confirm.cancel, q.scene, "cancel")
+}
+
+// showIcon sends the icon to the nuimo handle
+func (c *controller) showIcon(icon string, s *state, action string) {
+	if icon == "" {
+		return
+	}
+	c.dispatchCommand(Command{Handle: "nuimo", Text: icon, Scene: s.Name, Action: action, Event: nuimo.Event{Key: action}})
+}
@@ -0,0 +1,81 @@
+package scenes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+)
+
+const confirmConfig = `
+confirm:
+  pause: 50ms
+scenes:
+  light:
+    swipe_down:
+      do: "fhem: set lamp off"
+      confirm: true
+    rotate_right:
+      do: "fhem: set lamp on"
+      confirm: true
+    fly_left:
+      do: "fhem: set lamp toggle"
+      debounce: 0s
+      confirm: true
+`
+
+func TestConfirmRepeatedGesture(t *testing.T) {
+	c, r := newRecordingController(t, confirmConfig)
+	c.handleEvent(nuimo.Event{Key: "swipe_down"})
+	if got := handled(t, c, r); got != "[]" {
+		t.Fatalf("expected the action to wait for the confirmation, got %s", got)
+	}
+	c.handleEvent(nuimo.Event{Key: "swipe_down"})
+	if got := handled(t, c, r); got != "[set lamp off]" {
+		t.Errorf("expected the confirmed action, got %s", got)
+	}
+}
+
+func TestConfirmContinuousGestureNeedsPause(t *testing.T) {
+	c, r := newRecordingController(t, confirmConfig)
+	for i := 0; i < 5; i++ {
+		c.handleEvent(nuimo.Event{Key: "rotate", Value: 20})
+	}
+	if got := handled(t, c, r); got != "[]" {
+		t.Fatalf("expected the ongoing rotation not to confirm, got %s", got)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	c.handleEvent(nuimo.Event{Key: "rotate", Value: 20})
+	if got := handled(t, c, r); got != "[set lamp on]" {
+		t.Errorf("expected the rotation after the pause to confirm, got %s", got)
+	}
+}
+
+func TestConfirmFlyNeedsPause(t *testing.T) {
+	c, r := newRecordingController(t, confirmConfig)
+	for i := 0; i < 5; i++ {
+		c.handleEvent(nuimo.Event{Key: "fly_left", Value: 100})
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := handled(t, c, r); got != "[]" {
+		t.Fatalf("expected the ongoing fly gesture not to confirm, got %s", got)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	c.handleEvent(nuimo.Event{Key: "fly_left", Value: 100})
+	if got := handled(t, c, r); got != "[set lamp toggle]" {
+		t.Errorf("expected the fly gesture after the pause to confirm, got %s", got)
+	}
+}
+
+func TestConfirmCancelledByOtherGesture(t *testing.T) {
+	c, r := newRecordingController(t, confirmConfig)
+	c.handleEvent(nuimo.Event{Key: "rotate", Value: 20})
+	c.handleEvent(nuimo.Event{Key: "swipe_down"})
+	time.Sleep(100 * time.Millisecond)
+	c.handleEvent(nuimo.Event{Key: "rotate", Value: 20})
+	if got := handled(t, c, r); got != "[]" {
+		t.Errorf("expected the other gesture to cancel the question, got %s", got)
+	}
+}
@@ -1,38 +1,98 @@
 package scenes
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"sync"
+	"time"
 
 	"github.com/mgutz/logxi/v1"
 	"github.com/spf13/viper"
-	"github.com/tolleiv/nuimo"
+	"github.com/tolleiv/nuimo-fhem/metrics"
+	"github.com/tolleiv/nuimo-fhem/nuimo"
 )
 
 type controller struct {
-	states           []*state
-	nullState        *state
-	current          int
-	commandListeners map[string][]chan string
+	mu           sync.Mutex
+	pending      sync.WaitGroup
+	ctx          context.Context
+	cancel       context.CancelFunc
+	states       []*state
+	globals      map[string]*variable
+	nav          navigation
+	pressed      bool
+	rotation     int64
+	heldPress    *nuimo.Event
+	picker       *picker
+	question     *question
+	confirm      *confirmSettings
+	lock         *childLock
+	nullState    *state
+	globalState  *state
+	current      int
+	workers      map[string]*worker
+	blocked      map[*queue]bool
+	limiter      *limiter
+	traces       map[string]io.Writer
+	injected     chan nuimo.Event
+	history      history
+	connected    bool
+	nuimoState   nuimo.State
+	dropped      func() uint64
+	droppedSeen  uint64
+	connects     int
+	battery      int64
+	batteryState *battery
+	stateFile    string
+	persistTimer *time.Timer
+	// writing serializes the writes of the state file, saved is the last written state
+	writing sync.Mutex
+	saved   []byte
 }
 
 var logger = log.New("nuimo-fhem")
 
+var (
+	eventsReceived     = metrics.NewCounterVec("nuimo_fhem_events_total", "Number of received Nuimo events.", "key")
+	commandsDispatched = metrics.NewCounterVec("nuimo_fhem_commands_total", "Number of dispatched commands.", "handle", "scene")
+	bleReconnects      = metrics.NewCounterVec("nuimo_fhem_ble_reconnects_total", "Number of reconnects to the Nuimo.")
+	bleState           = metrics.NewGaugeVec("nuimo_fhem_ble_state", "Connection state of the Nuimo, 1 for the current state.", "state")
+	eventsDropped      = metrics.NewCounterVec("nuimo_fhem_events_dropped_total", "Number of Nuimo events dropped because they were not read in time.")
+	batteryLevel       = metrics.NewGauge("nuimo_fhem_battery_level", "Last reported battery level of the Nuimo in percent.")
+	sceneIndex         = metrics.NewGauge("nuimo_fhem_scene_index", "Index of the current scene.")
+)
+
 func NewController() *controller {
-	c := &controller{current: 0}
-	c.commandListeners = make(map[string][]chan string)
+	c := &controller{current: 0, battery: -1}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.injected = make(chan nuimo.Event, 16)
+	c.workers = make(map[string]*worker)
+	c.blocked = make(map[*queue]bool)
+	c.limiter = newLimiter()
+	c.traces = make(map[string]io.Writer)
 
 	viper.SetConfigName("scenes")
 	viper.AddConfigPath(".")
 	viper.ReadInConfig()
 
-	defaultScene := viper.GetStringMapString("default")
+	c.batteryState = newBattery()
+	c.nav = globalNavigation()
+	c.picker = newPicker()
+	c.confirm = newConfirmSettings()
+	c.lock = newChildLock()
+	c.globals = newVariables("global", viper.GetStringMap("vars"))
+
+	defaultScene := viper.GetStringMap("default")
 	logger.Debug("Scene Default")
 	c.nullState = NewState("null", defaultScene)
+	c.globalState = NewState("global", viper.GetStringMap("global"))
 
 	scenes := viper.GetStringMap("scenes")
 	for scene, _ := range scenes {
 		logger.Debug("Scene", scene)
-		c.appendState(NewState(scene, viper.GetStringMapString("scenes."+scene)))
+		c.appendState(NewState(scene, viper.GetStringMap("scenes."+scene)))
 	}
 
 	return c
@@ -45,34 +105,82 @@ func (c *controller) appendState(s *state) {
 	c.states = append(c.states, s)
 }
 
-func (c *controller) Listen(events <-chan nuimo.Event) {
+// Listen handles the incoming events until the event channel is closed or the context is cancelled
+func (c *controller) Listen(ctx context.Context, events <-chan nuimo.Event) {
 	logger.Info("Nuimo ready to receive events")
 	for {
-		event := <-events
-		logger.Debug(fmt.Sprintf("Event: %s %x %d", event.Key, event.Raw, event.Value))
-		switch event.Key {
-		case "swipe_left":
-			c.dispatchCommand(c.prevState(), event)
-		case "swipe_right":
-			c.dispatchCommand(c.nextState(), event)
-		case "rotate":
-			if event.Value > 10 {
-				c.dispatchCommand(c.CurrentState().Handle("rotate_right"), event)
-			} else if event.Value < -10 {
-				c.dispatchCommand(c.CurrentState().Handle("rotate_left"), event)
+		var event nuimo.Event
+		select {
+		case <-ctx.Done():
+			logger.Info("Stop listening for Nuimo events")
+			return
+		case e, more := <-events:
+			if !more {
+				logger.Info("Nuimo event stream closed")
+				return
 			}
-		case "press", "release", "swipe_up", "swipe_down":
-			c.dispatchCommand(c.CurrentState().Handle(event.Key), event)
-		case "swipe":
-			// ignore
-		case "battery":
-			c.dispatchCommand(c.nullState.Handle("battery"), event)
-		case "connected", "disconnected":
-			c.dispatchCommand(c.nullState.Handle(event.Key), event)
-		default:
-			logger.Warn(fmt.Sprintf("Unhandled event: %s %x %d", event.Key, event.Raw, event.Value))
-			c.dispatchCommand(c.nullState.Handle(event.Key), event)
+			event = e
+		case event = <-c.injected:
+			logger.Debug("Injected event", event.Key)
 		}
+		c.handleEvent(event)
+		c.waitForRoom(ctx)
+	}
+}
+
+// Inject queues a synthetic event which is handled as if it was sent by the Nuimo
+func (c *controller) Inject(event nuimo.Event) error {
+	select {
+	case c.injected <- event:
+		return nil
+	default:
+		return errors.New("Too many queued events")
+	}
+}
+
+func (c *controller) handleEvent(event nuimo.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	logger.Debug(fmt.Sprintf("Event: %s %x %d (seq %d)", event.Key, event.Raw, event.Value, event.Seq))
+	received := event.Time
+	if received.IsZero() {
+		received = time.Now()
+	}
+	c.history.addEvent(EventEntry{Time: received, Key: event.Key, Value: event.Value})
+	eventsReceived.Inc(event.Key)
+	c.updateDropped()
+	defer c.persist()
+	c.observe(func(h Handler) {
+		if o, ok := h.(EventObserver); ok {
+			o.ObserveEvent(event)
+		}
+	})
+
+	switch event.Key {
+	case "rotate", "press", "release", "swipe_left", "swipe_right", "swipe_up", "swipe_down",
+		"fly_left", "fly_right", "fly_towards", "fly_backwards", "fly_updown",
+		"touch_left", "touch_right", "touch_top", "touch_bottom",
+		"longtouch_left", "longtouch_right", "longtouch_top", "longtouch_bottom":
+		c.interact(event)
+	case "swipe":
+		// ignore
+	case "battery":
+		c.handleBattery(event)
+	case "lock", "unlock":
+		c.setLocked(event.Key == "lock")
+	case "connected", "disconnected":
+		c.connected = event.Key == "connected"
+		if c.connected {
+			if c.connects > 0 {
+				bleReconnects.Inc()
+			}
+			c.connects++
+		}
+		c.dispatch(c.CurrentState(), event.Key, event)
+	default:
+		logger.Warn(fmt.Sprintf("Unhandled event: %s %x %d", event.Key, event.Raw, event.Value))
+		c.dispatch(c.CurrentState(), event.Key, event)
 	}
 }
 
@@ -80,45 +188,159 @@ func (c *controller) CurrentState() *state {
 	return c.states[c.current]
 }
 
-func (c *controller) nextState() string {
-	c.current = (c.current + 1) % len(c.states)
-	return c.CurrentState().Handle("id")
+func (c *controller) nextState() *state {
+	c.setCurrent((c.current + 1) % len(c.states))
+	return c.CurrentState()
 }
-func (c *controller) prevState() string {
-	c.current = (c.current + len(c.states) - 1) % len(c.states)
-	return c.CurrentState().Handle("id")
+func (c *controller) prevState() *state {
+	c.setCurrent((c.current + len(c.states) - 1) % len(c.states))
+	return c.CurrentState()
 }
 
-func (c *controller) AddCommandListener(prefix string, responseChannel chan string) {
-	if _, present := c.commandListeners[prefix]; present {
-		c.commandListeners[prefix] =
-			append(c.commandListeners[prefix], responseChannel)
-	} else {
-		c.commandListeners[prefix] = []chan string{responseChannel}
-	}
+func (c *controller) setCurrent(idx int) {
+	c.current = idx
+	sceneIndex.Set(float64(idx))
+
+	name := c.states[idx].Name
+	c.observe(func(h Handler) {
+		if o, ok := h.(SceneObserver); ok {
+			o.ObserveScene(name)
+		}
+	})
 }
 
-func (c *controller) RemoveCommandListener(prefix string, listenerChannel chan string) {
-	if _, present := c.commandListeners[prefix]; present {
-		for idx, _ := range c.commandListeners[prefix] {
-			if c.commandListeners[prefix][idx] == listenerChannel {
-				c.commandListeners[prefix] = append(c.commandListeners[prefix][:idx],
-					c.commandListeners[prefix][idx+1:]...)
-				break
-			}
+// TraceCommands writes every command for the given handle together with the scene,
+// event and template it was rendered from to w.
+func (c *controller) TraceCommands(prefix string, w io.Writer) {
+	c.traces[prefix] = w
+}
+
+func (c *controller) dispatch(s *state, name string, event nuimo.Event) {
+	c.dispatchAction(s, name, event, false)
+}
+
+// dispatchAction runs the action of the scene, actions which need a confirmation are only
+// run when confirmed is set
+func (c *controller) dispatchAction(s *state, name string, event nuimo.Event, confirmed bool) {
+	a := c.resolve(s, name)
+	if a == nil {
+		logger.Debug("No action", s.Name, name)
+		return
+	}
+	logger.Debug("State Handle", s.Name, name, a.command)
+
+	if a.confirm != nil && !confirmed {
+		c.ask(s, name, event, a.confirm)
+		return
+	}
+
+	data := c.templateData(s, event)
+	for _, u := range a.updates {
+		if err := c.apply(s, u, data); err != nil {
+			logger.Error("Unable to update variable", "scene", s.Name, "action", name, "err", err)
+			return
 		}
 	}
+	if len(a.updates) > 0 {
+		data = c.templateData(s, event)
+	}
+
+	cmd, err := NewCommand(a.command, data)
+	if err != nil {
+		logger.Error("Unable to dispatch command", "scene", s.Name, "action", name, "err", err)
+		return
+	}
+	if cmd.Handle == "empty" {
+		return
+	}
+	cmd.Scene = s.Name
+	cmd.Action = name
+
+	flush := func(cmd Command) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.dispatchCommand(cmd)
+		c.pending.Done()
+	}
+	switch {
+	case a.debounce > 0:
+		c.pending.Add(1)
+		if c.limiter.debounce(*cmd, a.debounce, flush) {
+			c.pending.Done()
+		}
+		return
+	case a.rate > 0:
+		c.pending.Add(1)
+		admitted, replaced := c.limiter.admit(*cmd, a.rate, flush)
+		if replaced {
+			c.pending.Done()
+		}
+		if !admitted {
+			logger.Debug("Command held back", cmd.Handle, cmd.Text)
+			return
+		}
+		c.pending.Done()
+	}
+
+	c.dispatchCommand(*cmd)
 }
 
-func (c *controller) dispatchCommand(fullCommand string, event nuimo.Event) {
+func (c *controller) dispatchCommand(cmd Command) {
 
-	cmd, _ := NewCommand(fullCommand, event)
+	commandsDispatched.Inc(cmd.Handle, cmd.Scene)
+	c.history.addCommand(CommandEntry{Time: time.Now(), Handle: cmd.Handle, Command: cmd.Text, Scene: cmd.Scene, Action: cmd.Action})
 
-	if _, present := c.commandListeners[cmd.handle]; present {
-		for _, handler := range c.commandListeners[cmd.handle] {
-			go func(handler chan string) {
-				handler <- cmd.command
-			}(handler)
+	if w, present := c.traces[cmd.Handle]; present {
+		fmt.Fprintf(w, "%s: %s (scene: %s, action: %s, event: %s=%d, template: %q)\n",
+			cmd.Handle, cmd.Text, cmd.Scene, cmd.Action, cmd.Event.Key, cmd.Event.Value, cmd.Template)
+	}
+
+	c.enqueue(cmd)
+}
+
+// Shutdown dispatches the on_shutdown action of the default scene and waits until all
+// queued commands are handled or the timeout passed. Afterwards the handlers are stopped
+// and closed, remaining commands are dropped and pending state changes are written.
+func (c *controller) Shutdown(timeout time.Duration) bool {
+	c.mu.Lock()
+	c.dispatch(c.CurrentState(), "on_shutdown", nuimo.Event{Key: "shutdown"})
+	c.mu.Unlock()
+
+	flushed := c.Wait(timeout)
+	c.cancel()
+	c.flushState()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, w := range c.workers {
+		if remaining := w.queue.close(); len(remaining) > 0 {
+			logger.Warn("Dropped queued commands", "handle", name, "count", len(remaining))
+			for range remaining {
+				c.pending.Done()
+			}
+		}
+		if closer, ok := w.handler.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				logger.Warn("Unable to close handler", "name", name, "err", err)
+			}
 		}
 	}
+	return flushed
+}
+
+// Wait blocks until all queued commands are handled. It returns false when the timeout
+// passed before.
+func (c *controller) Wait(timeout time.Duration) bool {
+	done := make(chan bool)
+	go func() {
+		c.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		logger.Warn("Commands still pending after timeout", "timeout", timeout)
+		return false
+	}
 }
@@ -0,0 +1,181 @@
+package scenes
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tolleiv/nuimo-fhem/metrics"
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+)
+
+// stuck is a handler which doesn't return before the context is done
+type stuck struct {
+	started chan Command
+}
+
+func (s *stuck) Handle(ctx context.Context, cmd Command) (Result, error) {
+	s.started <- cmd
+	<-ctx.Done()
+	return Result{}, nil
+}
+
+func TestBlockingQueueKeepsStatusAndShutdown(t *testing.T) {
+	c := newTestController(t, `
+default:
+  on_shutdown: "fhem: set lamp off"
+scenes:
+  light:
+    press: "fhem: set lamp on"
+`)
+	h := &stuck{started: make(chan Command, 8)}
+	if err := c.Handle("fhem", h, HandlerOptions{QueueSize: 1, Overflow: Block}); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan nuimo.Event)
+	go c.Listen(context.Background(), events)
+	events <- nuimo.Event{Key: "press"}
+	<-h.started
+	events <- nuimo.Event{Key: "press"}
+	// the third command waits for room in the queue
+	go func() { events <- nuimo.Event{Key: "press"} }()
+	time.Sleep(20 * time.Millisecond)
+
+	status := make(chan Status)
+	go func() { status <- c.Status() }()
+	select {
+	case st := <-status:
+		if st.Scene != "light" {
+			t.Errorf("unexpected scene %s", st.Scene)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Status blocked on the full queue")
+	}
+
+	shutdown := make(chan bool)
+	go func() { shutdown <- c.Shutdown(100 * time.Millisecond) }()
+	select {
+	case flushed := <-shutdown:
+		if flushed {
+			t.Error("expected the stuck commands to be reported as not flushed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown blocked on the full queue")
+	}
+}
+
+// gate is a handler which records the commands and handles each of them only once it is opened
+type gate struct {
+	recorder
+	open chan bool
+}
+
+func (g *gate) Handle(ctx context.Context, cmd Command) (Result, error) {
+	select {
+	case <-g.open:
+	case <-ctx.Done():
+	}
+	return g.recorder.Handle(ctx, cmd)
+}
+
+func TestBlockingQueueKeepsOrder(t *testing.T) {
+	c := newTestController(t, `
+scenes:
+  light:
+    id: "fhem: id"
+    touch_top: "fhem: set lamp {{.Value}}"
+`)
+	g := &gate{open: make(chan bool)}
+	if err := c.Handle("fhem", g, HandlerOptions{QueueSize: 1, Overflow: Block}); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan nuimo.Event)
+	go c.Listen(context.Background(), events)
+	// the first command is taken by the handler, the second one queued and the third waits
+	for i := int64(1); i <= 3; i++ {
+		events <- nuimo.Event{Key: "touch_top", Value: i}
+	}
+	select {
+	case events <- nuimo.Event{Key: "touch_top", Value: 4}:
+		t.Fatal("expected the events to be held up while a command waits")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// the controller is not locked meanwhile, the commands of the API wait in order
+	switched := make(chan error)
+	go func() { switched <- c.SwitchScene("light") }()
+	select {
+	case err := <-switched:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("SwitchScene blocked on the full queue")
+	}
+
+	go func() { events <- nuimo.Event{Key: "touch_top", Value: 4} }()
+	for i := 0; i < 5; i++ {
+		g.open <- true
+	}
+	if got := handled(t, c, &g.recorder); got != "[set lamp 1 set lamp 2 set lamp 3 id set lamp 4]" {
+		t.Errorf("unexpected order %s", got)
+	}
+}
+
+func TestWatchStates(t *testing.T) {
+	c := newTestController(t, "scenes:\n  a: {}\n")
+	states := make(chan nuimo.State, 2)
+	states <- nuimo.Connecting
+	states <- nuimo.Subscribed
+	close(states)
+	c.WatchStates(states)
+
+	if got := c.Status().NuimoState; got != "subscribed" {
+		t.Errorf("expected the last state in the status, got %s", got)
+	}
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{`nuimo_fhem_ble_state{state="subscribed"} 1`, `nuimo_fhem_ble_state{state="connecting"} 0`} {
+		if !strings.Contains(w.Body.String(), line) {
+			t.Errorf("expected %s in the metrics", line)
+		}
+	}
+}
+
+func TestCountDropped(t *testing.T) {
+	c := newTestController(t, "scenes:\n  a: {}\n")
+	before := metricValue(t, "nuimo_fhem_events_dropped_total")
+	var dropped uint64 = 2
+	c.CountDropped(func() uint64 { return dropped })
+	dropped = 5
+	c.handleEvent(nuimo.Event{Key: "swipe_up"})
+
+	if got := c.Status().EventsDropped; got != 5 {
+		t.Errorf("expected 5 dropped events in the status, got %d", got)
+	}
+	if got := metricValue(t, "nuimo_fhem_events_dropped_total") - before; got != 5 {
+		t.Errorf("expected the counter to grow by 5, got %v", got)
+	}
+}
+
+// metricValue returns the served value of a metric without labels
+func metricValue(t *testing.T, name string) float64 {
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if strings.HasPrefix(line, name+" ") {
+			v, err := strconv.ParseFloat(strings.TrimPrefix(line, name+" "), 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return v
+		}
+	}
+	t.Fatalf("metric %s not found", name)
+	return 0
+}
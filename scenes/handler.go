@@ -0,0 +1,217 @@
+package scenes
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/spf13/viper"
+	"github.com/tolleiv/nuimo-fhem/metrics"
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+)
+
+// Result is the outcome of a handled command
+type Result struct {
+	Output string
+}
+
+// Handler processes the commands of a handle, e.g. all "fhem:" commands
+type Handler interface {
+	Handle(ctx context.Context, cmd Command) (Result, error)
+}
+
+// HandlerFunc adapts a function to the Handler interface
+type HandlerFunc func(ctx context.Context, cmd Command) (Result, error)
+
+func (f HandlerFunc) Handle(ctx context.Context, cmd Command) (Result, error) {
+	return f(ctx, cmd)
+}
+
+// EventObserver is implemented by handlers which want to see every Nuimo event
+type EventObserver interface {
+	ObserveEvent(event nuimo.Event)
+}
+
+// SceneObserver is implemented by handlers which want to know about scene changes
+type SceneObserver interface {
+	ObserveScene(name string)
+}
+
+// HandlerOptions configure how commands are delivered to a handler
+type HandlerOptions struct {
+	// Concurrency is the number of commands handled at the same time. With the default
+	// of one the commands are handled one after another in the order they were dispatched.
+	Concurrency int
+	// QueueSize is the number of commands waiting for the handler, defaults to 32
+	QueueSize int
+	// Overflow decides what happens to new commands when the queue is full
+	Overflow Overflow
+}
+
+// Factory creates a handler from the configuration. It returns a nil handler when the
+// integration is not configured.
+type Factory func() (Handler, HandlerOptions, error)
+
+var registry = struct {
+	sync.Mutex
+	factories map[string]Factory
+}{factories: make(map[string]Factory)}
+
+// Register makes a handler factory available by name, integrations call it from their init function
+func Register(name string, factory Factory) {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, present := registry.factories[name]; present {
+		panic("scenes: Register called twice for handler " + name)
+	}
+	registry.factories[name] = factory
+}
+
+// Registered returns the names of all registered handler factories
+func Registered() []string {
+	registry.Lock()
+	defer registry.Unlock()
+	names := make([]string, 0, len(registry.factories))
+	for name := range registry.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var (
+	queueDepth      = metrics.NewGaugeVec("nuimo_fhem_queue_depth", "Number of commands waiting for a handler.", "handler")
+	commandsDropped = metrics.NewCounterVec("nuimo_fhem_commands_dropped_total", "Number of commands dropped because the handler queue was full.", "handler")
+)
+
+type worker struct {
+	name    string
+	handler Handler
+	queue   *queue
+}
+
+// Handle registers the handler for all commands with the given handle. The options can be
+// overridden per handle in the handlers section of the configuration.
+func (c *controller) Handle(name string, h Handler, opts HandlerOptions) error {
+	key := "handlers." + name
+	if viper.IsSet(key + ".concurrency") {
+		opts.Concurrency = viper.GetInt(key + ".concurrency")
+	}
+	if viper.IsSet(key + ".queue_size") {
+		opts.QueueSize = viper.GetInt(key + ".queue_size")
+	}
+	if viper.IsSet(key + ".overflow") {
+		overflow, err := ParseOverflow(viper.GetString(key + ".overflow"))
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		opts.Overflow = overflow
+	}
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	if opts.QueueSize < 1 {
+		opts.QueueSize = 32
+	}
+	logger.Debug("Handler", name, "concurrency", opts.Concurrency, "queue", opts.QueueSize, "overflow", opts.Overflow)
+	w := &worker{name: name, handler: h, queue: newQueue(opts.QueueSize, opts.Overflow)}
+
+	c.mu.Lock()
+	c.workers[name] = w
+	c.mu.Unlock()
+
+	for i := 0; i < opts.Concurrency; i++ {
+		go c.work(w)
+	}
+	return nil
+}
+
+// HandleRegistered creates the handlers of all registered factories which are not yet handled explicitly
+func (c *controller) HandleRegistered() error {
+	for _, name := range Registered() {
+		c.mu.Lock()
+		_, present := c.workers[name]
+		c.mu.Unlock()
+		if present {
+			continue
+		}
+
+		registry.Lock()
+		factory := registry.factories[name]
+		registry.Unlock()
+
+		h, opts, err := factory()
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		if h == nil {
+			logger.Debug("Handler not configured", name)
+			continue
+		}
+		logger.Info("Handler registered", "name", name)
+		if err := c.Handle(name, h, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *controller) work(w *worker) {
+	for {
+		cmd, more := w.queue.pop()
+		if !more {
+			return
+		}
+		queueDepth.Set(float64(w.queue.len()), w.name)
+		result, err := w.handler.Handle(c.ctx, cmd)
+		if err != nil {
+			logger.Error("Command failed", "handle", w.name, "command", cmd.Text, "err", err)
+		} else if result.Output != "" {
+			logger.Info("Command output", "handle", w.name, "output", result.Output)
+		}
+		c.pending.Done()
+	}
+}
+
+// enqueue has to be called with the lock held, it never blocks. A command which waits for
+// room in a blocking queue holds up the next event in Listen instead, so that a stuck handler
+// neither blocks the status nor the shutdown.
+func (c *controller) enqueue(cmd Command) {
+	w, present := c.workers[cmd.Handle]
+	if !present {
+		logger.Debug("No handler for command", cmd.Handle, cmd.Text)
+		return
+	}
+	c.pending.Add(1)
+	dropped, waiting := w.queue.push(cmd)
+	for _, d := range dropped {
+		logger.Warn("Command dropped, queue is full", "handle", w.name, "command", d.Text)
+		commandsDropped.Inc(w.name)
+		c.pending.Done()
+	}
+	if waiting {
+		logger.Debug("Command waits for room in the queue", "handle", w.name, "command", cmd.Text)
+		c.blocked[w.queue] = true
+	}
+	queueDepth.Set(float64(w.queue.len()), w.name)
+}
+
+// waitForRoom blocks until the commands waiting for room in blocking queues were queued
+func (c *controller) waitForRoom(ctx context.Context) {
+	c.mu.Lock()
+	blocked := c.blocked
+	c.blocked = make(map[*queue]bool)
+	c.mu.Unlock()
+
+	for q := range blocked {
+		q.wait(ctx)
+	}
+}
+
+// observe has to be called with the lock held
+func (c *controller) observe(notify func(h Handler)) {
+	for _, w := range c.workers {
+		notify(w.handler)
+	}
+}
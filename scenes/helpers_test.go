@@ -0,0 +1,73 @@
+package scenes
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// newTestController creates a controller from the given scenes.yml content
+func newTestController(t *testing.T, config string) *controller {
+	viper.Reset()
+	viper.SetConfigType("yaml")
+	if err := viper.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+	return NewController()
+}
+
+// newRecordingController creates a controller from the given scenes.yml content which
+// records its fhem commands
+func newRecordingController(t *testing.T, config string) (*controller, *recorder) {
+	c := newTestController(t, config)
+	r := &recorder{}
+	if err := c.Handle("fhem", r, HandlerOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	return c, r
+}
+
+// recorder collects the handled commands
+type recorder struct {
+	mu       sync.Mutex
+	commands []string
+}
+
+func (r *recorder) Handle(ctx context.Context, cmd Command) (Result, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commands = append(r.commands, strings.TrimSpace(cmd.Text))
+	return Result{}, nil
+}
+
+func (r *recorder) handled() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.commands...)
+}
+
+// handled waits for the pending commands and returns the recorded ones, e.g. "[set lamp on]"
+func handled(t *testing.T, c *controller, r *recorder) string {
+	if !c.Wait(time.Second) {
+		t.Fatal("commands still pending")
+	}
+	return fmt.Sprint(r.handled())
+}
+
+// tempStateFile returns the path of a state file in a new temporary directory and a function
+// which removes the directory again
+func tempStateFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "nuimo-fhem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "state.json"), func() { os.RemoveAll(dir) }
+}
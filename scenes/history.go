@@ -0,0 +1,41 @@
+package scenes
+
+import "time"
+
+const historySize = 50
+
+// EventEntry describes a received Nuimo event
+type EventEntry struct {
+	Time  time.Time `json:"time"`
+	Key   string    `json:"key"`
+	Value int64     `json:"value"`
+}
+
+// CommandEntry describes a dispatched command
+type CommandEntry struct {
+	Time    time.Time `json:"time"`
+	Handle  string    `json:"handle"`
+	Command string    `json:"command"`
+	Scene   string    `json:"scene"`
+	Action  string    `json:"action"`
+}
+
+// history keeps the latest events and commands, older entries are dropped
+type history struct {
+	events   []EventEntry
+	commands []CommandEntry
+}
+
+func (h *history) addEvent(e EventEntry) {
+	h.events = append(h.events, e)
+	if len(h.events) > historySize {
+		h.events = h.events[len(h.events)-historySize:]
+	}
+}
+
+func (h *history) addCommand(c CommandEntry) {
+	h.commands = append(h.commands, c)
+	if len(h.commands) > historySize {
+		h.commands = h.commands[len(h.commands)-historySize:]
+	}
+}
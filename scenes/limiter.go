@@ -0,0 +1,111 @@
+package scenes
+
+import (
+	"regexp"
+	"sync"
+	"time"
+)
+
+var (
+	words  = regexp.MustCompile(`[\w.-]+`)
+	number = regexp.MustCompile(`^-?\d+(\.\d+)?$`)
+)
+
+// limiter rate limits commands per target. Commands arriving within the window of the
+// previous one are held back and only the latest of them is dispatched once the window
+// passed. This collapses identical commands and keeps only the latest value of commands
+// which just differ in numbers, e.g. "set lamp pct 40" and "set lamp pct 45".
+// Debounced commands are held back until no further command for the target arrived
+// within the window.
+type limiter struct {
+	mu        sync.Mutex
+	slots     map[string]*slot
+	debounced map[string]*slot
+}
+
+type slot struct {
+	last    time.Time
+	pending *Command
+	timer   *time.Timer
+}
+
+func newLimiter() *limiter {
+	return &limiter{slots: make(map[string]*slot), debounced: make(map[string]*slot)}
+}
+
+// target identifies the device and command a command is aimed at. Only numbers which stand
+// alone are values, digits within a word like "lamp1" are part of the device name.
+func target(cmd Command) string {
+	return cmd.Handle + ":" + words.ReplaceAllStringFunc(cmd.Text, func(word string) string {
+		if number.MatchString(word) {
+			return "#"
+		}
+		return word
+	})
+}
+
+// admit reports whether the command can be dispatched right away. Otherwise it is held
+// back and passed to flush after the window passed, unless a newer command replaced it.
+// replaced is true when the command took the place of one which was still held back.
+func (l *limiter) admit(cmd Command, window time.Duration, flush func(Command)) (admitted bool, replaced bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := target(cmd)
+	s, present := l.slots[key]
+	if !present {
+		s = &slot{}
+		l.slots[key] = s
+	}
+
+	now := time.Now()
+	if s.pending == nil && now.Sub(s.last) >= window {
+		s.last = now
+		return true, false
+	}
+
+	replaced = s.pending != nil
+	if !replaced {
+		time.AfterFunc(s.last.Add(window).Sub(now), func() {
+			l.mu.Lock()
+			pending := s.pending
+			s.pending = nil
+			s.last = time.Now()
+			l.mu.Unlock()
+			flush(*pending)
+		})
+	}
+	s.pending = &cmd
+	return false, replaced
+}
+
+// debounce holds the command back until the window passed without another command for
+// the same target and passes it to flush then. It returns true when the command replaced
+// one which was still held back.
+func (l *limiter) debounce(cmd Command, window time.Duration, flush func(Command)) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := target(cmd)
+	s, present := l.debounced[key]
+	if !present {
+		s = &slot{}
+		l.debounced[key] = s
+	}
+
+	replaced := s.pending != nil
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.pending = &cmd
+	s.timer = time.AfterFunc(window, func() {
+		l.mu.Lock()
+		pending := s.pending
+		s.pending = nil
+		l.mu.Unlock()
+		if pending != nil {
+			flush(*pending)
+		}
+	})
+	return replaced
+}
@@ -0,0 +1,80 @@
+package scenes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+)
+
+func TestTarget(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"set HUEDevice3 pct 40", "set HUEDevice3 pct 45", true},
+		{"set lamp dim -2.5", "set lamp dim 10", true},
+		{"set lamp pct=40", "set lamp pct=45", true},
+		{`home/lamp {"brightness": 40}`, `home/lamp {"brightness": 45}`, true},
+		{"set lamp1 on", "set lamp2 on", false},
+		{"set HUEDevice3 on", "set HUEDevice4 on", false},
+		{"set HUEDevice3 pct 40", "set HUEDevice4 pct 40", false},
+		{"set lamp on", "set lamp off", false},
+	}
+	for _, test := range tests {
+		a, b := target(Command{Handle: "fhem", Text: test.a}), target(Command{Handle: "fhem", Text: test.b})
+		if (a == b) != test.same {
+			t.Errorf("%q and %q: got targets %q and %q", test.a, test.b, a, b)
+		}
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	l := newLimiter()
+	flushed := make(chan Command, 4)
+	flush := func(cmd Command) { flushed <- cmd }
+
+	cmd := func(text string) Command { return Command{Handle: "fhem", Text: text} }
+	if admitted, _ := l.admit(cmd("set lamp pct 10"), 50*time.Millisecond, flush); !admitted {
+		t.Fatal("expected the first command to be admitted")
+	}
+	if admitted, replaced := l.admit(cmd("set lamp pct 20"), 50*time.Millisecond, flush); admitted || replaced {
+		t.Fatalf("expected the second command to be held back, got admitted=%t replaced=%t", admitted, replaced)
+	}
+	if admitted, replaced := l.admit(cmd("set lamp pct 30"), 50*time.Millisecond, flush); admitted || !replaced {
+		t.Fatalf("expected the third command to replace the second, got admitted=%t replaced=%t", admitted, replaced)
+	}
+	if admitted, _ := l.admit(cmd("set lamp2 pct 30"), 50*time.Millisecond, flush); !admitted {
+		t.Fatal("expected a command for another device to be admitted")
+	}
+
+	select {
+	case got := <-flushed:
+		if got.Text != "set lamp pct 30" {
+			t.Errorf("expected the latest command to be flushed, got %s", got.Text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("held back command was not flushed")
+	}
+	select {
+	case got := <-flushed:
+		t.Errorf("unexpected flush of %s", got.Text)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRateLimitedBurstIsFlushed(t *testing.T) {
+	c, r := newRecordingController(t, `
+scenes:
+  light:
+    rotate_right:
+      do: "fhem: set lamp pct {{.Value}}"
+      rate: 50ms
+`)
+	for i := int64(11); i <= 20; i++ {
+		c.handleEvent(nuimo.Event{Key: "rotate", Value: i})
+	}
+	if got := handled(t, c, r); got != "[set lamp pct 11 set lamp pct 20]" {
+		t.Errorf("expected the first and the latest command, got %s", got)
+	}
+}
@@ -0,0 +1,131 @@
+package scenes
+
+import (
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+)
+
+// childLock suppresses all gestures besides an allow-list while it is locked. It is
+// toggled by a sequence of gestures or from the outside, e.g. by FHEM through the API.
+type childLock struct {
+	sequence []string
+	within   time.Duration
+	allow    map[string]bool
+	icon     string
+
+	locked bool
+	// recent are the latest gestures to detect the sequence
+	recent []gestureEntry
+	shown  time.Time
+}
+
+type gestureEntry struct {
+	gesture string
+	time    time.Time
+}
+
+func newChildLock() *childLock {
+	l := &childLock{
+		sequence: cast.ToStringSlice(setting("lock", "sequence", []string{})),
+		within:   cast.ToDuration(setting("lock", "within", "3s")),
+		allow:    make(map[string]bool),
+		icon:     cast.ToString(setting("lock", "icon", "lock")),
+	}
+	for _, gesture := range cast.ToStringSlice(setting("lock", "allow", []string{})) {
+		l.allow[gesture] = true
+	}
+	return l
+}
+
+// completes records the gesture and returns true when it completes the sequence. Releases
+// and the repeated notifications of rotations and fly gestures are not part of a sequence.
+func (l *childLock) completes(gesture string, now time.Time) bool {
+	if len(l.sequence) == 0 || gesture == "release" || continuous(gesture) {
+		return false
+	}
+	l.recent = append(l.recent, gestureEntry{gesture, now})
+	if len(l.recent) > len(l.sequence) {
+		l.recent = l.recent[len(l.recent)-len(l.sequence):]
+	}
+	if len(l.recent) < len(l.sequence) || now.Sub(l.recent[0].time) > l.within {
+		return false
+	}
+	for idx, entry := range l.recent {
+		if entry.gesture != l.sequence[idx] {
+			return false
+		}
+	}
+	l.recent = nil
+	return true
+}
+
+// Lock locks or unlocks the controller
+func (c *controller) Lock(locked bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setLocked(locked)
+	c.persist()
+}
+
+// ReportLock runs the locked or unlocked action for the current state of the child lock. It
+// is called once the handlers are registered, e.g. to report a lock restored from the state file.
+func (c *controller) ReportLock() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name := "unlocked"
+	if c.lock.locked {
+		name = "locked"
+	}
+	c.dispatch(c.CurrentState(), name, nuimo.Event{Key: name})
+}
+
+// setLocked has to be called with the lock held. The change is reported by the locked and
+// unlocked actions, e.g. to set a reading in FHEM.
+func (c *controller) setLocked(locked bool) {
+	if c.lock.locked == locked {
+		return
+	}
+	c.lock.locked = locked
+	logger.Info("Child lock", "locked", locked)
+
+	name := "unlocked"
+	if locked {
+		name = "locked"
+		c.picker.active = false
+		c.question = nil
+		c.heldPress = nil
+	}
+	c.dispatch(c.CurrentState(), name, nuimo.Event{Key: name})
+	if locked {
+		c.showIcon(c.lock.icon, c.CurrentState(), name)
+	} else {
+		c.preview(c.CurrentState())
+	}
+}
+
+// locked toggles the lock when the gesture completes the sequence and returns true when
+// the gesture must not be handled
+func (c *controller) locked(gesture string) bool {
+	if gesture == "" {
+		return false
+	}
+
+	now := time.Now()
+	if c.lock.completes(gesture, now) {
+		c.setLocked(!c.lock.locked)
+		return true
+	}
+	if !c.lock.locked || c.lock.allow[gesture] {
+		return false
+	}
+
+	// show the lock icon, but not for every event of a gesture
+	if now.Sub(c.lock.shown) > 2*time.Second {
+		c.lock.shown = now
+		c.showIcon(c.lock.icon, c.CurrentState(), "locked")
+	}
+	return true
+}
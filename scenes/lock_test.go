@@ -0,0 +1,100 @@
+package scenes
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+)
+
+const lockConfig = `
+default:
+  locked: "fhem: setreading wz_Nuimo lock on"
+  unlocked: "fhem: setreading wz_Nuimo lock off"
+scenes:
+  light:
+    press: "fhem: set lamp on"
+`
+
+func TestReportRestoredLock(t *testing.T) {
+	path, cleanup := tempStateFile(t)
+	defer cleanup()
+	if err := ioutil.WriteFile(path, []byte(`{"scene": "light", "battery": -1, "locked": true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, r := newRecordingController(t, lockConfig)
+	if err := c.PersistState(path); err != nil {
+		t.Fatal(err)
+	}
+	c.ReportLock()
+	if got := handled(t, c, r); got != "[setreading wz_Nuimo lock on]" {
+		t.Errorf("expected the restored lock to be reported, got %s", got)
+	}
+
+	c.Lock(false)
+	c.Lock(false)
+	if !c.Wait(time.Second) {
+		t.Fatal("commands still pending")
+	}
+	if got := handled(t, c, r); got != "[setreading wz_Nuimo lock on setreading wz_Nuimo lock off]" {
+		t.Errorf("expected a single unlocked report, got %s", got)
+	}
+}
+
+func TestLockSequence(t *testing.T) {
+	c, r := newRecordingController(t, `
+lock:
+  sequence: [press, press, press]
+  allow: [swipe_down]
+default:
+  locked: "fhem: locked"
+  unlocked: "fhem: unlocked"
+scenes:
+  light:
+    press: "fhem: press"
+    rotate_right: "fhem: rotate"
+    swipe_up: "fhem: up"
+    swipe_down: "fhem: down"
+`)
+	// releases and rotations in between don't break the sequence, the presses before the
+	// last one still run their action
+	for _, key := range []string{"press", "release", "rotate", "press", "release", "press", "release"} {
+		c.handleEvent(nuimo.Event{Key: key, Value: 20})
+	}
+	if got := handled(t, c, r); got != "[press rotate press locked]" {
+		t.Fatalf("expected the sequence to lock, got %s", got)
+	}
+
+	// only the allowed gestures work while locked
+	for _, key := range []string{"swipe_up", "rotate", "swipe_down"} {
+		c.handleEvent(nuimo.Event{Key: key, Value: 20})
+	}
+	if got := handled(t, c, r); got != "[press rotate press locked down]" {
+		t.Fatalf("expected only the allowed gesture, got %s", got)
+	}
+
+	for _, key := range []string{"press", "release", "press", "release", "press", "release", "swipe_up"} {
+		c.handleEvent(nuimo.Event{Key: key})
+	}
+	if got := handled(t, c, r); got != "[press rotate press locked down unlocked up]" {
+		t.Errorf("expected the sequence to unlock, got %s", got)
+	}
+}
+
+func TestLockSequenceWithin(t *testing.T) {
+	l := &childLock{sequence: []string{"longtouch_left", "longtouch_right"}, within: time.Second}
+	start := time.Now()
+
+	if l.completes("longtouch_left", start) || l.completes("longtouch_right", start.Add(2*time.Second)) {
+		t.Error("expected the sequence to take too long")
+	}
+	if l.completes("longtouch_right", start.Add(2*time.Second)) {
+		t.Error("expected the order of the sequence to matter")
+	}
+	l.completes("longtouch_left", start.Add(3*time.Second))
+	if !l.completes("longtouch_right", start.Add(3500*time.Millisecond)) {
+		t.Error("expected the sequence to complete")
+	}
+}
@@ -0,0 +1,133 @@
+package scenes
+
+import (
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+)
+
+// navigation names the gestures which switch to the previous and next scene. An empty
+// gesture falls back to the global navigation, "none" disables it. Rotations switch the
+// scene once per step, which is only set globally.
+type navigation struct {
+	prev string
+	next string
+	step int64
+}
+
+func newNavigation(value interface{}) navigation {
+	if cast.ToString(value) == "none" {
+		return navigation{prev: "none", next: "none"}
+	}
+	settings := cast.ToStringMap(value)
+	return navigation{prev: cast.ToString(settings["prev"]), next: cast.ToString(settings["next"])}
+}
+
+func globalNavigation() navigation {
+	step := cast.ToInt64(setting("navigation", "step", 150))
+	if viper.GetString("navigation") == "none" {
+		return navigation{prev: "none", next: "none", step: step}
+	}
+	return navigation{
+		prev: cast.ToString(setting("navigation", "prev", "swipe_left")),
+		next: cast.ToString(setting("navigation", "next", "swipe_right")),
+		step: step,
+	}
+}
+
+// pressed is true when the navigation uses gestures made while the button is pressed
+func (n navigation) pressed() bool {
+	return strings.HasPrefix(n.prev, "press+") || strings.HasPrefix(n.next, "press+")
+}
+
+// navigation returns the navigation of the scene, filled up with the global one
+func (c *controller) navigation(s *state) navigation {
+	nav := s.navigation
+	if nav.prev == "" {
+		nav.prev = c.nav.prev
+	}
+	if nav.next == "" {
+		nav.next = c.nav.next
+	}
+	return nav
+}
+
+// gesture returns the name a scene binds the event to. Rotations below the threshold are
+// ignored, rotations while the button is pressed are called press+rotate_left/right.
+func (c *controller) gesture(event nuimo.Event) string {
+	switch event.Key {
+	case "rotate":
+		name := ""
+		if event.Value > 10 {
+			name = "rotate_right"
+		} else if event.Value < -10 {
+			name = "rotate_left"
+		}
+		if name != "" && c.pressed {
+			name = "press+" + name
+		}
+		return name
+	case "press":
+		c.pressed = true
+		c.rotation = 0
+	case "release":
+		c.pressed = false
+		c.rotation = 0
+	}
+	return event.Key
+}
+
+// step returns true when the event moves the navigation on. Rotations are notified many
+// times while the Nuimo is turned, they are summed up and only every step switches the scene.
+func (c *controller) step(event nuimo.Event) bool {
+	if event.Key != "rotate" {
+		return true
+	}
+	c.rotation += event.Value
+	if c.rotation > -c.nav.step && c.rotation < c.nav.step {
+		return false
+	}
+	c.rotation = 0
+	return true
+}
+
+// interact switches the scene when the gesture is a navigation gesture of the current
+// scene and dispatches the action of the current scene otherwise. When the navigation
+// uses gestures made while the button is pressed, the press action is held back until the
+// release and dropped when the press was used to switch the scene.
+func (c *controller) interact(event nuimo.Event) {
+	gesture := c.gesture(event)
+	if c.locked(gesture) || c.answer(gesture) || c.pick(gesture, event) || gesture == "" {
+		return
+	}
+
+	nav := c.navigation(c.CurrentState())
+	switch gesture {
+	case nav.prev, nav.next:
+		if !c.step(event) {
+			return
+		}
+		c.heldPress = nil
+		if gesture == nav.prev {
+			c.dispatch(c.prevState(), "id", event)
+		} else {
+			c.dispatch(c.nextState(), "id", event)
+		}
+	case "press":
+		if nav.pressed() {
+			c.heldPress = &event
+			return
+		}
+		c.dispatch(c.CurrentState(), gesture, event)
+	case "release":
+		if c.heldPress != nil {
+			c.dispatch(c.CurrentState(), "press", *c.heldPress)
+			c.heldPress = nil
+		}
+		c.dispatch(c.CurrentState(), gesture, event)
+	default:
+		c.dispatch(c.CurrentState(), gesture, event)
+	}
+}
@@ -0,0 +1,133 @@
+package scenes
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+)
+
+const navigationConfig = `
+navigation:
+  step: 100
+default:
+  id: "fhem: id {{.Scene}}"
+  press: "fhem: press {{.Scene}}"
+  release: "fhem: release {{.Scene}}"
+scenes:
+  a:
+    navigation:
+      prev: press+rotate_left
+      next: press+rotate_right
+  b:
+    navigation:
+      prev: press+rotate_left
+      next: press+rotate_right
+  c:
+    navigation:
+      prev: press+rotate_left
+      next: press+rotate_right
+`
+
+// sceneAt returns the name of the scene the given number of switches away from the first one
+func sceneAt(c *controller, switches int) string {
+	idx := switches % len(c.states)
+	if idx < 0 {
+		idx += len(c.states)
+	}
+	return c.states[idx].Name
+}
+
+func TestNavigationDefaults(t *testing.T) {
+	c, r := newRecordingController(t, `
+default:
+  id: "fhem: id {{.Scene}}"
+  swipe_up: "fhem: swipe_up {{.Scene}}"
+scenes:
+  a: {}
+  b: {}
+  c: {}
+`)
+	c.handleEvent(nuimo.Event{Key: "swipe_right"})
+	c.handleEvent(nuimo.Event{Key: "swipe_right"})
+	c.handleEvent(nuimo.Event{Key: "swipe_left"})
+	c.handleEvent(nuimo.Event{Key: "swipe_up"})
+	want := fmt.Sprintf("[id %s id %s id %s swipe_up %s]", sceneAt(c, 1), sceneAt(c, 2), sceneAt(c, 1), sceneAt(c, 1))
+	if got := handled(t, c, r); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestNavigationRotationSteps(t *testing.T) {
+	c, r := newRecordingController(t, navigationConfig)
+	c.handleEvent(nuimo.Event{Key: "press"})
+	// five notifications of a single turn switch the scene once per step
+	for i := 0; i < 5; i++ {
+		c.handleEvent(nuimo.Event{Key: "rotate", Value: 40})
+	}
+	if c.current != 1 {
+		t.Errorf("expected a single switch, got scene %d", c.current)
+	}
+	for i := 0; i < 6; i++ {
+		c.handleEvent(nuimo.Event{Key: "rotate", Value: -40})
+	}
+	c.handleEvent(nuimo.Event{Key: "release"})
+
+	// the rotation left over from the first switch is used up by the turn back first
+	want := fmt.Sprintf("[id %s id %s release %s]", sceneAt(c, 1), sceneAt(c, 0), sceneAt(c, 0))
+	if got := handled(t, c, r); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestNavigationHoldsPressBack(t *testing.T) {
+	c, r := newRecordingController(t, navigationConfig)
+
+	// a press without rotation runs the press action on release
+	c.handleEvent(nuimo.Event{Key: "press"})
+	if got := handled(t, c, r); got != "[]" {
+		t.Errorf("expected the press to be held back, got %s", got)
+	}
+	c.handleEvent(nuimo.Event{Key: "release"})
+	first := sceneAt(c, 0)
+	want := fmt.Sprintf("[press %s release %s]", first, first)
+	if got := handled(t, c, r); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	// a press which switches the scene does not run the press action
+	c.handleEvent(nuimo.Event{Key: "press"})
+	c.handleEvent(nuimo.Event{Key: "rotate", Value: 5})
+	c.handleEvent(nuimo.Event{Key: "rotate", Value: 120})
+	c.handleEvent(nuimo.Event{Key: "release"})
+	second := sceneAt(c, 1)
+	want = fmt.Sprintf("[press %s release %s id %s release %s]", first, first, second, second)
+	if got := handled(t, c, r); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestNavigationNone(t *testing.T) {
+	c, r := newRecordingController(t, `
+navigation: none
+default:
+  id: "fhem: id {{.Scene}}"
+  swipe_right: "fhem: swipe_right {{.Scene}}"
+scenes:
+  a:
+    press: "fhem: press"
+    navigation:
+      next: swipe_up
+  b:
+    press: "fhem: press"
+    navigation:
+      next: swipe_up
+`)
+	c.handleEvent(nuimo.Event{Key: "swipe_right"})
+	c.handleEvent(nuimo.Event{Key: "swipe_up"})
+	c.handleEvent(nuimo.Event{Key: "press"})
+	want := fmt.Sprintf("[swipe_right %s id %s press]", sceneAt(c, 0), sceneAt(c, 1))
+	if got := handled(t, c, r); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,137 @@
+package scenes
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// persisted is the part of the controller state which survives restarts
+type persisted struct {
+	Scene       string                            `json:"scene"`
+	Battery     int64                             `json:"battery"`
+	BatteryIcon time.Time                         `json:"battery_icon"`
+	Locked      bool                              `json:"locked"`
+	Globals     map[string]interface{}            `json:"globals,omitempty"`
+	Vars        map[string]map[string]interface{} `json:"vars,omitempty"`
+}
+
+// PersistState restores the current scene, battery level, child lock and variables from
+// the given file and writes changes back to it, at most once per second. A missing file is
+// fine, scenes which no longer exist are ignored.
+func (c *controller) PersistState(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stateFile = path
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		logger.Info("No state to restore", "file", path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var p persisted
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	c.saved = data
+
+	c.lock.locked = p.Locked
+	restore(c.globals, p.Globals)
+	for idx, s := range c.states {
+		if s.Name == p.Scene {
+			c.setCurrent(idx)
+		}
+		restore(s.vars, p.Vars[s.Name])
+	}
+	if c.CurrentState().Name != p.Scene {
+		logger.Warn("Persisted scene does not exist anymore", "scene", p.Scene)
+	}
+	if p.Battery >= 0 {
+		c.battery = p.Battery
+		batteryLevel.Set(float64(p.Battery))
+		c.batteryState.transition(p.Battery)
+		c.batteryState.iconShown = p.BatteryIcon
+	}
+	logger.Info("State restored", "file", path, "scene", c.CurrentState().Name)
+	return nil
+}
+
+// persistDelay collects the state changes of a burst of events into a single write
+var persistDelay = time.Second
+
+// persist schedules writing the state file, it has to be called with the lock held
+func (c *controller) persist() {
+	if c.stateFile == "" || c.persistTimer != nil {
+		return
+	}
+	c.persistTimer = time.AfterFunc(persistDelay, c.flushState)
+}
+
+// flushState writes the state file when the state changed since it was last written.
+// Only the snapshot is taken with the lock held, the file is written without it.
+func (c *controller) flushState() {
+	c.writing.Lock()
+	defer c.writing.Unlock()
+
+	c.mu.Lock()
+	c.persistTimer = nil
+	path := c.stateFile
+	p := persisted{Scene: c.CurrentState().Name, Battery: c.battery, BatteryIcon: c.batteryState.iconShown, Locked: c.lock.locked, Globals: values(c.globals)}
+	for _, s := range c.states {
+		if len(s.vars) > 0 {
+			if p.Vars == nil {
+				p.Vars = make(map[string]map[string]interface{})
+			}
+			p.Vars[s.Name] = values(s.vars)
+		}
+	}
+	c.mu.Unlock()
+
+	if path == "" {
+		return
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		logger.Error("Unable to encode state", "err", err)
+		return
+	}
+	if bytes.Equal(data, c.saved) {
+		return
+	}
+	if err := writeAtomic(path, data); err != nil {
+		logger.Error("Unable to write state", "file", path, "err", err)
+		return
+	}
+	c.saved = data
+}
+
+// writeAtomic writes into a temporary file next to path and renames it, so a crash never
+// leaves a half written file behind
+func writeAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,94 @@
+package scenes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+)
+
+const persistConfig = `
+vars:
+  level: 0
+scenes:
+  light:
+    rotate_right:
+      increment: level
+`
+
+func readState(t *testing.T, path string) (persisted, bool) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return persisted{}, false
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p persisted
+	if err := json.Unmarshal(data, &p); err != nil {
+		t.Fatal(err)
+	}
+	return p, true
+}
+
+func TestPersistCoalescesWrites(t *testing.T) {
+	defer func(d time.Duration) { persistDelay = d }(persistDelay)
+	persistDelay = 50 * time.Millisecond
+
+	path, cleanup := tempStateFile(t)
+	defer cleanup()
+
+	c := newTestController(t, persistConfig)
+	if err := c.PersistState(path); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		c.handleEvent(nuimo.Event{Key: "rotate", Value: 20})
+	}
+	if _, written := readState(t, path); written {
+		t.Fatal("state was written right away")
+	}
+
+	time.Sleep(4 * persistDelay)
+	p, written := readState(t, path)
+	if !written {
+		t.Fatal("state was not written after the delay")
+	}
+	if level := p.Globals["level"]; level != float64(5) {
+		t.Errorf("expected the state after the burst, got level %v", level)
+	}
+}
+
+func TestShutdownWritesState(t *testing.T) {
+	defer func(d time.Duration) { persistDelay = d }(persistDelay)
+	persistDelay = time.Hour
+
+	path, cleanup := tempStateFile(t)
+	defer cleanup()
+
+	c := newTestController(t, persistConfig)
+	if err := c.PersistState(path); err != nil {
+		t.Fatal(err)
+	}
+	c.handleEvent(nuimo.Event{Key: "rotate", Value: 20})
+	c.Shutdown(time.Second)
+
+	p, written := readState(t, path)
+	if !written {
+		t.Fatal("state was not written on shutdown")
+	}
+	if level := p.Globals["level"]; level != float64(1) {
+		t.Errorf("expected level 1, got %v", level)
+	}
+
+	restored := newTestController(t, persistConfig)
+	if err := restored.PersistState(path); err != nil {
+		t.Fatal(err)
+	}
+	if level := values(restored.globals)["level"]; level != int64(1) {
+		t.Errorf("expected the restored level to be 1, got %v (%T)", level, level)
+	}
+}
@@ -0,0 +1,117 @@
+package scenes
+
+import (
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+)
+
+// picker lets the user choose a scene directly: the enter gesture starts it, rotating
+// scrolls through the scene icons and a press selects the shown scene. Without input the
+// picker is cancelled after the timeout and the current scene stays.
+type picker struct {
+	enter   string
+	timeout time.Duration
+	step    int64
+
+	active   bool
+	index    int
+	rotation int64
+	// generation tells timers of earlier picks apart
+	generation int
+	// swallowRelease drops the release of the press which selected the scene
+	swallowRelease bool
+}
+
+func newPicker() *picker {
+	return &picker{
+		enter:   cast.ToString(setting("picker", "enter", "")),
+		timeout: cast.ToDuration(setting("picker", "timeout", "5s")),
+		step:    cast.ToInt64(setting("picker", "step", 150)),
+	}
+}
+
+// pick handles the event when the picker is active or entered, it returns false for
+// events the scenes should handle
+func (c *controller) pick(gesture string, event nuimo.Event) bool {
+	p := c.picker
+	if !p.active {
+		if gesture == "release" && p.swallowRelease {
+			p.swallowRelease = false
+			return true
+		}
+		if p.enter == "" || gesture != p.enter {
+			return false
+		}
+		logger.Debug("Scene picker started")
+		p.active = true
+		p.index = c.current
+		p.rotation = 0
+		c.preview(c.states[p.index])
+		c.restartPicker()
+		return true
+	}
+
+	switch {
+	case event.Key == "rotate":
+		p.rotation += event.Value
+		if p.rotation > -p.step && p.rotation < p.step {
+			return true
+		}
+		if p.rotation > 0 {
+			p.index = (p.index + 1) % len(c.states)
+		} else {
+			p.index = (p.index + len(c.states) - 1) % len(c.states)
+		}
+		p.rotation = 0
+		c.preview(c.states[p.index])
+		c.restartPicker()
+	case gesture == "press":
+		logger.Debug("Scene picked", c.states[p.index].Name)
+		p.active = false
+		p.swallowRelease = true
+		c.setCurrent(p.index)
+		c.dispatch(c.CurrentState(), "id", event)
+	case gesture == p.enter:
+		c.cancelPicker()
+	}
+	return true
+}
+
+// restartPicker restarts the timeout, it has to be called with the lock held
+func (c *controller) restartPicker() {
+	c.picker.generation++
+	generation := c.picker.generation
+	time.AfterFunc(c.picker.timeout, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.picker.active && c.picker.generation == generation {
+			c.cancelPicker()
+		}
+	})
+}
+
+// cancelPicker leaves the picker and shows the current scene again
+func (c *controller) cancelPicker() {
+	logger.Debug("Scene picker cancelled")
+	c.picker.active = false
+	c.picker.generation++
+	c.preview(c.CurrentState())
+}
+
+// preview shows the icon of the scene without switching to it. Only the nuimo command of
+// the id action is dispatched.
+func (c *controller) preview(s *state) {
+	a := c.resolve(s, "id")
+	if a == nil {
+		return
+	}
+	cmd, err := NewCommand(a.command, c.templateData(s, nuimo.Event{Key: "picker"}))
+	if err != nil || cmd.Handle != "nuimo" {
+		return
+	}
+	cmd.Scene = s.Name
+	cmd.Action = "preview"
+	c.dispatchCommand(*cmd)
+}
@@ -0,0 +1,121 @@
+package scenes
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+)
+
+const pickerConfig = `
+picker:
+  enter: longtouch_bottom
+  timeout: 100ms
+  step: 100
+default:
+  id: "nuimo: {{.Scene}}"
+  press: "nuimo: press {{.Scene}}"
+  release: "nuimo: release {{.Scene}}"
+scenes:
+  a: {}
+  b: {}
+  c: {}
+`
+
+// newPickerController creates a controller from the picker configuration which records the
+// shown icons and the other nuimo commands
+func newPickerController(t *testing.T) (*controller, *recorder) {
+	c := newTestController(t, pickerConfig)
+	r := &recorder{}
+	if err := c.Handle("nuimo", r, HandlerOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	return c, r
+}
+
+func TestPickerStepping(t *testing.T) {
+	c, r := newPickerController(t)
+	for _, event := range []nuimo.Event{
+		{Key: "longtouch_bottom"},
+		{Key: "rotate", Value: 60},
+		{Key: "rotate", Value: 60},
+		{Key: "rotate", Value: -100},
+		{Key: "rotate", Value: -100},
+		{Key: "press"},
+		{Key: "release"},
+		{Key: "press"},
+		{Key: "release"},
+	} {
+		c.handleEvent(event)
+	}
+
+	// the previews of the first, second, first and last scene, the selection of the last one
+	// and the press and release in it, the release of the selecting press is swallowed
+	first, second, last := sceneAt(c, 0), sceneAt(c, 1), sceneAt(c, 2)
+	want := fmt.Sprintf("[%s %s %s %s %s press %s release %s]", first, second, first, last, last, last, last)
+	if got := handled(t, c, r); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+	if c.current != 2 || c.picker.active {
+		t.Errorf("expected the last scene to be picked, got %d (active %v)", c.current, c.picker.active)
+	}
+}
+
+func TestPickerTimeout(t *testing.T) {
+	c, r := newPickerController(t)
+	c.handleEvent(nuimo.Event{Key: "longtouch_bottom"})
+	c.handleEvent(nuimo.Event{Key: "rotate", Value: 100})
+	// every step restarts the timeout
+	time.Sleep(60 * time.Millisecond)
+	c.handleEvent(nuimo.Event{Key: "rotate", Value: 100})
+	time.Sleep(60 * time.Millisecond)
+	c.mu.Lock()
+	active := c.picker.active
+	c.mu.Unlock()
+	if !active {
+		t.Fatal("expected the picker to wait for the timeout after the last step")
+	}
+	time.Sleep(100 * time.Millisecond)
+	c.handleEvent(nuimo.Event{Key: "press"})
+
+	first := sceneAt(c, 0)
+	want := fmt.Sprintf("[%s %s %s %s press %s]", first, sceneAt(c, 1), sceneAt(c, 2), first, first)
+	if got := handled(t, c, r); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+	if c.current != 0 {
+		t.Errorf("expected the current scene to stay, got %d", c.current)
+	}
+}
+
+func TestPickerCancel(t *testing.T) {
+	c, r := newPickerController(t)
+	first, second := sceneAt(c, 0), sceneAt(c, 1)
+
+	// the enter gesture leaves the picker again
+	c.handleEvent(nuimo.Event{Key: "longtouch_bottom"})
+	c.handleEvent(nuimo.Event{Key: "rotate", Value: 100})
+	c.handleEvent(nuimo.Event{Key: "longtouch_bottom"})
+	want := fmt.Sprintf("[%s %s %s]", first, second, first)
+	if got := handled(t, c, r); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	// locking leaves the picker, the press after unlocking runs the scene action
+	r.mu.Lock()
+	r.commands = nil
+	r.mu.Unlock()
+	c.handleEvent(nuimo.Event{Key: "longtouch_bottom"})
+	c.handleEvent(nuimo.Event{Key: "rotate", Value: 100})
+	c.handleEvent(nuimo.Event{Key: "lock"})
+	c.handleEvent(nuimo.Event{Key: "unlock"})
+	c.handleEvent(nuimo.Event{Key: "press"})
+	want = fmt.Sprintf("[%s %s lock %s press %s]", first, second, first, first)
+	if got := handled(t, c, r); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+	if c.current != 0 || c.picker.active {
+		t.Errorf("expected the current scene to stay, got %d (active %v)", c.current, c.picker.active)
+	}
+}
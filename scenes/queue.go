@@ -0,0 +1,166 @@
+package scenes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Overflow is the back-pressure policy applied when a handler queue is full
+type Overflow int
+
+const (
+	// Block keeps the command waiting in order behind the full queue, events are not handled
+	// until the handler took it and the command is dropped when the controller shuts down before
+	Block Overflow = iota
+	// DropOldest removes the oldest queued command to make room for the new one
+	DropOldest
+	// Coalesce replaces a queued command of the same scene action with the new one and
+	// drops the oldest command when there is none
+	Coalesce
+)
+
+func (o Overflow) String() string {
+	switch o {
+	case DropOldest:
+		return "drop_oldest"
+	case Coalesce:
+		return "coalesce"
+	default:
+		return "block"
+	}
+}
+
+// ParseOverflow parses the policy names used in the configuration
+func ParseOverflow(name string) (Overflow, error) {
+	switch strings.ToLower(name) {
+	case "", "block":
+		return Block, nil
+	case "drop_oldest":
+		return DropOldest, nil
+	case "coalesce":
+		return Coalesce, nil
+	}
+	return Block, fmt.Errorf("Unknown overflow policy %s", name)
+}
+
+// queue is a bounded FIFO of commands waiting for a handler. With the Block policy the
+// commands which don't fit wait in order behind the queue.
+type queue struct {
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	drained  *sync.Cond
+	items    []Command
+	waiting  []Command
+	size     int
+	overflow Overflow
+	closed   bool
+}
+
+func newQueue(size int, overflow Overflow) *queue {
+	q := &queue{size: size, overflow: overflow, items: make([]Command, 0, size)}
+	q.notEmpty = sync.NewCond(&q.mu)
+	q.drained = sync.NewCond(&q.mu)
+	return q
+}
+
+// push appends the command and returns the commands which were dropped to make room for it.
+// push never blocks, with the Block policy it reports that the command waits for room
+// instead and the caller can hold up further commands with wait.
+func (q *queue) push(cmd Command) (dropped []Command, waiting bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return []Command{cmd}, false
+	}
+	if len(q.items) >= q.size {
+		switch q.overflow {
+		case Block:
+			q.waiting = append(q.waiting, cmd)
+			return nil, true
+		case Coalesce:
+			for idx, queued := range q.items {
+				if queued.Scene == cmd.Scene && queued.Action == cmd.Action {
+					q.items[idx] = cmd
+					return []Command{queued}, false
+				}
+			}
+			fallthrough
+		case DropOldest:
+			dropped = append(dropped, q.items[0])
+			q.items = q.items[1:]
+		}
+	}
+
+	q.items = append(q.items, cmd)
+	q.notEmpty.Signal()
+	return dropped, false
+}
+
+// wait blocks until all waiting commands are in the queue. It returns false when the queue
+// was closed or the context is done before.
+func (q *queue) wait(ctx context.Context) bool {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			q.mu.Lock()
+			q.drained.Broadcast()
+			q.mu.Unlock()
+		case <-stop:
+		}
+	}()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.waiting) > 0 && !q.closed && ctx.Err() == nil {
+		q.drained.Wait()
+	}
+	return !q.closed && ctx.Err() == nil
+}
+
+// pop waits for the next command, it returns false once the queue is closed
+func (q *queue) pop() (Command, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.items) == 0 && !q.closed {
+		q.notEmpty.Wait()
+	}
+	if q.closed {
+		return Command{}, false
+	}
+	cmd := q.items[0]
+	q.items = q.items[1:]
+	if len(q.waiting) > 0 {
+		q.items = append(q.items, q.waiting[0])
+		q.waiting = q.waiting[1:]
+		if len(q.waiting) == 0 {
+			q.drained.Broadcast()
+		}
+	}
+	return cmd, true
+}
+
+// len returns the number of queued and waiting commands
+func (q *queue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items) + len(q.waiting)
+}
+
+// close wakes up all waiting callers and returns the commands which were still queued or waiting
+func (q *queue) close() []Command {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	remaining := append(q.items, q.waiting...)
+	q.items, q.waiting = nil, nil
+	q.notEmpty.Broadcast()
+	q.drained.Broadcast()
+	return remaining
+}
@@ -0,0 +1,179 @@
+package scenes
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func texts(cmds []Command) []string {
+	var out []string
+	for _, cmd := range cmds {
+		out = append(out, cmd.Text)
+	}
+	return out
+}
+
+func drain(q *queue) []string {
+	var out []string
+	for q.len() > 0 {
+		cmd, _ := q.pop()
+		out = append(out, cmd.Text)
+	}
+	return out
+}
+
+func TestQueueOrderUnderBursts(t *testing.T) {
+	for _, overflow := range []Overflow{Block, DropOldest, Coalesce} {
+		q := newQueue(4, overflow)
+		ctx := context.Background()
+
+		const producers, burst = 4, 100
+		var wg sync.WaitGroup
+		for p := 0; p < producers; p++ {
+			wg.Add(1)
+			go func(p int) {
+				defer wg.Done()
+				for i := 0; i < burst; i++ {
+					cmd := Command{Text: fmt.Sprintf("%d %d", p, i), Scene: "scene", Action: fmt.Sprint(p, i)}
+					if _, waiting := q.push(cmd); waiting {
+						q.wait(ctx)
+					}
+				}
+			}(p)
+		}
+		remaining := make(chan []Command)
+		go func() {
+			wg.Wait()
+			remaining <- q.close()
+		}()
+
+		var received []Command
+		for {
+			cmd, more := q.pop()
+			if !more {
+				break
+			}
+			received = append(received, cmd)
+			if overflow == Block {
+				// give the producers a chance to fill up the queue
+				time.Sleep(time.Microsecond)
+			}
+		}
+		received = append(received, <-remaining...)
+
+		last := make(map[int]int)
+		for _, cmd := range received {
+			var p, i int
+			fmt.Sscanf(cmd.Text, "%d %d", &p, &i)
+			if prev, seen := last[p]; seen && i <= prev {
+				t.Errorf("%s: command %d of producer %d arrived after %d", overflow, i, p, prev)
+			}
+			last[p] = i
+		}
+		if overflow == Block && len(received) != producers*burst {
+			t.Errorf("%s: expected all %d commands, got %d", overflow, producers*burst, len(received))
+		}
+	}
+}
+
+func TestQueueBlock(t *testing.T) {
+	q := newQueue(2, Block)
+	q.push(Command{Text: "a"})
+	q.push(Command{Text: "b"})
+
+	dropped, waiting := q.push(Command{Text: "c"})
+	if !waiting || len(dropped) != 0 {
+		t.Fatalf("expected the command to wait without drops, got waiting=%t dropped=%v", waiting, texts(dropped))
+	}
+	// later commands wait behind it
+	q.push(Command{Text: "d"})
+
+	room := make(chan bool)
+	go func() { room <- q.wait(context.Background()) }()
+	select {
+	case <-room:
+		t.Fatal("wait returned while the queue was full")
+	case <-time.After(20 * time.Millisecond):
+	}
+	q.pop()
+	select {
+	case <-room:
+		t.Fatal("wait returned while a command was still waiting")
+	case <-time.After(20 * time.Millisecond):
+	}
+	q.pop()
+	if !<-room {
+		t.Fatal("expected the waiting commands to be queued after two pops")
+	}
+	if got := fmt.Sprint(drain(q)); got != "[c d]" {
+		t.Errorf("unexpected queue content %s", got)
+	}
+}
+
+func TestQueueBlockCancel(t *testing.T) {
+	q := newQueue(1, Block)
+	q.push(Command{Text: "a"})
+	q.push(Command{Text: "b"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	room := make(chan bool)
+	go func() { room <- q.wait(ctx) }()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case ok := <-room:
+		if ok {
+			t.Error("expected wait to fail after the context was cancelled")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("wait ignored the cancelled context")
+	}
+
+	go func() { room <- q.wait(context.Background()) }()
+	time.Sleep(10 * time.Millisecond)
+	if remaining := q.close(); fmt.Sprint(texts(remaining)) != "[a b]" {
+		t.Errorf("expected the queued and the waiting command to be returned, got %v", texts(remaining))
+	}
+	if <-room {
+		t.Error("expected wait to fail after the queue was closed")
+	}
+	if dropped, waiting := q.push(Command{Text: "c"}); waiting || fmt.Sprint(texts(dropped)) != "[c]" {
+		t.Errorf("expected a closed queue to drop the command, got waiting=%t dropped=%v", waiting, texts(dropped))
+	}
+}
+
+func TestQueueDropOldest(t *testing.T) {
+	q := newQueue(2, DropOldest)
+	q.push(Command{Text: "a"})
+	q.push(Command{Text: "b"})
+
+	dropped, waiting := q.push(Command{Text: "c"})
+	if waiting || fmt.Sprint(texts(dropped)) != "[a]" {
+		t.Fatalf("expected the oldest command to be dropped, got waiting=%t dropped=%v", waiting, texts(dropped))
+	}
+	if got := fmt.Sprint(drain(q)); got != "[b c]" {
+		t.Errorf("unexpected queue content %s", got)
+	}
+}
+
+func TestQueueCoalesce(t *testing.T) {
+	q := newQueue(2, Coalesce)
+	q.push(Command{Text: "volume 10", Scene: "sonos", Action: "rotate_right"})
+	q.push(Command{Text: "pause", Scene: "sonos", Action: "press"})
+
+	dropped, _ := q.push(Command{Text: "volume 12", Scene: "sonos", Action: "rotate_right"})
+	if fmt.Sprint(texts(dropped)) != "[volume 10]" {
+		t.Fatalf("expected the queued command of the same action to be replaced, got %v", texts(dropped))
+	}
+
+	dropped, _ = q.push(Command{Text: "next", Scene: "sonos", Action: "swipe_right"})
+	if fmt.Sprint(texts(dropped)) != "[volume 12]" {
+		t.Fatalf("expected the oldest command to be dropped, got %v", texts(dropped))
+	}
+	if got := fmt.Sprint(drain(q)); got != "[pause next]" {
+		t.Errorf("unexpected queue content %s", got)
+	}
+}
@@ -0,0 +1,54 @@
+package scenes
+
+// maxSameAs limits how often actions can refer to other actions
+const maxSameAs = 4
+
+// chain returns the scenes which are asked for an action in order: the scene itself, its
+// parents, the global bindings and the default scene
+func (c *controller) chain(s *state) []*state {
+	chain := []*state{}
+	seen := make(map[string]bool)
+	for s != nil && !seen[s.Name] {
+		seen[s.Name] = true
+		chain = append(chain, s)
+		s = c.scene(s.parent)
+	}
+	return append(chain, c.globalState, c.nullState)
+}
+
+// scene returns the scene with the given name, nil when there is none
+func (c *controller) scene(name string) *state {
+	if name == "" {
+		return nil
+	}
+	for _, s := range c.states {
+		if s.Name == name {
+			return s
+		}
+	}
+	logger.Warn("Unknown parent scene", "scene", name)
+	return nil
+}
+
+// resolve looks the action up along the chain of the scene. It returns nil when no scene
+// has the action or it was suppressed with "none".
+func (c *controller) resolve(s *state, name string) *action {
+	for i := 0; i <= maxSameAs; i++ {
+		var found *action
+		for _, candidate := range c.chain(s) {
+			if a, present := candidate.action(name); present {
+				found = a
+				break
+			}
+		}
+		if found == nil || found.suppressed() {
+			return nil
+		}
+		if found.sameAs == "" {
+			return found
+		}
+		name = found.sameAs
+	}
+	logger.Warn("Too many same_as references", "scene", s.Name, "action", name)
+	return nil
+}
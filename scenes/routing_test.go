@@ -0,0 +1,93 @@
+package scenes
+
+import (
+	"testing"
+
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+)
+
+const routingConfig = `
+vars:
+  volume: 10
+default:
+  battery: "fhem: default battery {{.Value}}"
+  swipe_down: "fhem: default swipe_down"
+global:
+  touch_top: "fhem: global touch_top"
+  swipe_down: "fhem: global swipe_down"
+scenes:
+  music:
+    vars:
+      cnt: 0
+    swipe_up:
+      increment: cnt
+      do: "fhem: music {{.Vars.cnt}} {{.Vars.volume}} {{.Scene}}"
+    touch_left: "fhem: music touch_left"
+  radio:
+    parent: music
+    vars:
+      volume: 3
+    touch_left: none
+    battery: "fhem: radio battery {{.Value}}"
+    longtouch_top:
+      do: "fhem: radio {{.Vars.unknown}}"
+    longtouch_bottom:
+      increment: unknown
+      do: "fhem: radio longtouch_bottom"
+`
+
+func TestRouting(t *testing.T) {
+	c, r := newRecordingController(t, routingConfig)
+	if err := c.SwitchScene("radio"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		event nuimo.Event
+		want  string
+	}{
+		// the variable of the parent is updated, the scene variable hides the global one
+		{nuimo.Event{Key: "swipe_up"}, "[music 1 3 radio]"},
+		{nuimo.Event{Key: "swipe_up"}, "[music 2 3 radio]"},
+		// suppressed in the scene
+		{nuimo.Event{Key: "touch_left"}, "[]"},
+		// the global bindings come before the default scene
+		{nuimo.Event{Key: "touch_top"}, "[global touch_top]"},
+		{nuimo.Event{Key: "swipe_down"}, "[global swipe_down]"},
+		// status events can be overridden by the scene
+		{nuimo.Event{Key: "battery", Value: 80}, "[radio battery 80]"},
+		// unknown variables abort the action instead of sending "<no value>"
+		{nuimo.Event{Key: "longtouch_top"}, "[]"},
+		{nuimo.Event{Key: "longtouch_bottom"}, "[]"},
+	}
+	for _, test := range tests {
+		r.mu.Lock()
+		r.commands = nil
+		r.mu.Unlock()
+		c.handleEvent(test.event)
+		if got := handled(t, c, r); got != test.want {
+			t.Errorf("%s: expected %s, got %s", test.event.Key, test.want, got)
+		}
+	}
+
+	if cnt := values(c.scene("music").vars)["cnt"]; cnt != int64(2) {
+		t.Errorf("expected the parent variable to be 2, got %v", cnt)
+	}
+	if _, present := c.scene("radio").vars["cnt"]; present {
+		t.Error("expected the variable to stay in the parent scene")
+	}
+}
+
+func TestRoutingDefaultScene(t *testing.T) {
+	c, r := newRecordingController(t, routingConfig)
+	if err := c.SwitchScene("music"); err != nil {
+		t.Fatal(err)
+	}
+
+	c.handleEvent(nuimo.Event{Key: "battery", Value: 80})
+	c.handleEvent(nuimo.Event{Key: "touch_left"})
+	c.handleEvent(nuimo.Event{Key: "swipe_up"})
+	if got := handled(t, c, r); got != "[default battery 80 music touch_left music 1 10 music]" {
+		t.Errorf("unexpected commands %s", got)
+	}
+}
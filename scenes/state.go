@@ -1,22 +1,127 @@
 package scenes
 
+import (
+	"strings"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+// action is what a scene does for an event. In the configuration it is either just the
+// command or a map with the command under "do" and further settings.
+type action struct {
+	command string
+	// rate is the minimum time between two commands of this action for the same target
+	rate time.Duration
+	// debounce holds the command back until the event did not repeat for this long
+	debounce time.Duration
+	// updates change variables before the command is rendered
+	updates []update
+	// sameAs names another action of the scene which is dispatched instead
+	sameAs string
+	// confirm asks the user to confirm the action before it is run
+	confirm *confirmation
+}
+
+// suppressed is true for actions set to "none", which stop the lookup in parent scenes
+func (a *action) suppressed() bool {
+	return strings.TrimSpace(a.command) == "none"
+}
+
 type state struct {
-	Name     string
-	commands map[string]string
+	Name       string
+	actions    map[string]*action
+	vars       map[string]*variable
+	navigation navigation
+	// parent is the scene which is asked for actions this scene does not have
+	parent string
+}
+
+func NewState(name string, stateActions map[string]interface{}) *state {
+	actions := make(map[string]*action)
+	vars := make(map[string]*variable)
+	var nav navigation
+	var parent string
+
+	for prop, value := range stateActions {
+		switch prop {
+		case "vars":
+			vars = newVariables(name, cast.ToStringMap(value))
+			continue
+		case "navigation":
+			nav = newNavigation(value)
+			continue
+		case "parent":
+			parent = cast.ToString(value)
+			continue
+		}
+		logger.Debug("--->setting", prop, value)
+		a, err := newAction(value, actionDefaults(prop))
+		if err != nil {
+			logger.Error("Invalid action", "scene", name, "action", prop, "err", err)
+			continue
+		}
+		actions[prop] = a
+	}
+
+	return &state{Name: name, actions: actions, vars: vars, navigation: nav, parent: parent}
 }
 
-func NewState(name string, stateCommands map[string]string) *state {
-	cmds := make(map[string]string)
+// actionDefaults returns the settings an action has unless configured otherwise
+func actionDefaults(event string) action {
+	if strings.HasPrefix(event, "fly_") {
+		return action{debounce: cast.ToDuration(setting("fly", "debounce", "200ms"))}
+	}
+	return action{}
+}
+
+func newAction(value interface{}, defaults action) (*action, error) {
+	a := &defaults
+	settings, err := cast.ToStringMapE(value)
+	if err != nil {
+		a.command = cast.ToString(value)
+		return a, nil
+	}
 
-	for prop, command := range stateCommands {
-		logger.Debug("--->setting", prop, command)
-		cmds[prop] = command
+	a.command = cast.ToString(settings["do"])
+	a.sameAs = cast.ToString(settings["same_as"])
+	if rate, present := settings["rate"]; present {
+		if a.rate, err = cast.ToDurationE(rate); err != nil {
+			return nil, err
+		}
 	}
+	if a.confirm, err = newConfirmation(settings["confirm"]); err != nil {
+		return nil, err
+	}
+	if debounce, present := settings["debounce"]; present {
+		if a.debounce, err = cast.ToDurationE(debounce); err != nil {
+			return nil, err
+		}
+	}
+	for _, op := range []string{"set", "increment", "cycle"} {
+		switch args := settings[op].(type) {
+		case nil:
+		case []interface{}:
+			for _, arg := range args {
+				a.updates = append(a.updates, update{op: op, args: cast.ToString(arg)})
+			}
+		default:
+			a.updates = append(a.updates, update{op: op, args: cast.ToString(args)})
+		}
+	}
+	return a, nil
+}
 
-	return &state{Name: name, commands: cmds}
+func (s *state) action(event string) (*action, bool) {
+	a, present := s.actions[event]
+	return a, present
 }
 
 func (s *state) Handle(event string) string {
-	logger.Debug("State Handle", s.Name, event, s.commands[event])
-	return s.commands[event]
+	a, _ := s.action(event)
+	if a == nil {
+		return ""
+	}
+	logger.Debug("State Handle", s.Name, event, a.command)
+	return a.command
 }
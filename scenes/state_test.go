@@ -0,0 +1,50 @@
+package scenes
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFlyDebounce(t *testing.T) {
+	tests := []struct {
+		config string
+		want   time.Duration
+	}{
+		{`
+scenes:
+  light:
+    fly_left: "fhem: set lamp off"
+`, 200 * time.Millisecond},
+		{`
+fly:
+  other: true
+scenes:
+  light:
+    fly_left: "fhem: set lamp off"
+`, 200 * time.Millisecond},
+		{`
+fly:
+  debounce: 50ms
+scenes:
+  light:
+    fly_left: "fhem: set lamp off"
+`, 50 * time.Millisecond},
+		{`
+scenes:
+  light:
+    fly_left:
+      do: "fhem: set lamp off"
+      debounce: 0s
+`, 0},
+	}
+	for _, test := range tests {
+		c := newTestController(t, test.config)
+		a, ok := c.states[0].action("fly_left")
+		if !ok {
+			t.Fatalf("no fly_left action in %s", test.config)
+		}
+		if a.debounce != test.want {
+			t.Errorf("expected debounce %s, got %s for %s", test.want, a.debounce, test.config)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package scenes
+
+import (
+	"fmt"
+
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+)
+
+// Status is a snapshot of the controller state
+type Status struct {
+	Scene          string         `json:"scene"`
+	Scenes         []string       `json:"scenes"`
+	NuimoConnected bool           `json:"nuimo_connected"`
+	NuimoState     string         `json:"nuimo_state"`
+	EventsDropped  uint64         `json:"events_dropped"`
+	Battery        int64          `json:"battery"`
+	BatteryState   string         `json:"battery_state"`
+	BatteryHistory []BatteryEntry `json:"battery_history"`
+	Locked         bool           `json:"locked"`
+	Events         []EventEntry   `json:"events"`
+	Commands       []CommandEntry `json:"commands"`
+}
+
+// Status returns the current scene, the known scenes, the Nuimo connection state, the last
+// battery level (-1 when unknown), state and history, the child lock and the recent events
+// and dispatched commands.
+func (c *controller) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st := Status{
+		Scene:          c.CurrentState().Name,
+		NuimoConnected: c.connected,
+		NuimoState:     c.nuimoState.String(),
+		EventsDropped:  c.updateDropped(),
+		Battery:        c.battery,
+		BatteryState:   c.batteryState.state,
+		BatteryHistory: append([]BatteryEntry{}, c.batteryState.history...),
+		Locked:         c.lock.locked,
+		Events:         append([]EventEntry{}, c.history.events...),
+		Commands:       append([]CommandEntry{}, c.history.commands...),
+	}
+	for _, s := range c.states {
+		st.Scenes = append(st.Scenes, s.Name)
+	}
+	return st
+}
+
+// WatchStates follows the connection states of the Nuimo, e.g. from nuimo.States(), and
+// reports them in the status and the metrics until the channel is closed
+func (c *controller) WatchStates(states <-chan nuimo.State) {
+	for state := range states {
+		c.mu.Lock()
+		c.nuimoState = state
+		c.mu.Unlock()
+		for s := nuimo.Disconnected; s <= nuimo.Degraded; s++ {
+			value := 0.0
+			if s == state {
+				value = 1
+			}
+			bleState.Set(value, s.String())
+		}
+	}
+}
+
+// CountDropped reports the number of events the Nuimo dropped, e.g. from nuimo.Dropped, in the
+// status and the metrics
+func (c *controller) CountDropped(dropped func() uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dropped = dropped
+	c.updateDropped()
+}
+
+// updateDropped adds the events dropped since the last call to the counter and returns
+// their total, c.mu is held
+func (c *controller) updateDropped() uint64 {
+	if c.dropped == nil {
+		return 0
+	}
+	total := c.dropped()
+	if total > c.droppedSeen {
+		eventsDropped.Add(float64(total - c.droppedSeen))
+		c.droppedSeen = total
+	}
+	return total
+}
+
+// SwitchScene makes the named scene the current one and shows its icon
+func (c *controller) SwitchScene(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for idx, s := range c.states {
+		if s.Name == name {
+			c.setCurrent(idx)
+			c.dispatch(s, "id", nuimo.Event{Key: "scene"})
+			c.persist()
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown scene %s", name)
+}
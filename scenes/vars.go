@@ -0,0 +1,227 @@
+package scenes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+)
+
+// variable is a declared scene or global variable. Its value is either a number or a string.
+type variable struct {
+	value interface{}
+	// values are cycled through by the cycle action
+	values []string
+	min    *int64
+	max    *int64
+	step   int64
+}
+
+// newVariable reads a declaration which is either the initial value, a list of values to
+// cycle through or a map with value, values, min, max and step.
+func newVariable(decl interface{}) (*variable, error) {
+	v := &variable{step: 1}
+
+	settings, err := cast.ToStringMapE(decl)
+	if err != nil {
+		settings = map[string]interface{}{"value": decl}
+		if list, ok := decl.([]interface{}); ok {
+			settings = map[string]interface{}{"values": list}
+		}
+	}
+
+	if values, present := settings["values"]; present {
+		v.values = cast.ToStringSlice(values)
+		if len(v.values) == 0 {
+			return nil, fmt.Errorf("no values to cycle through")
+		}
+		v.value = v.values[0]
+	}
+	for _, bound := range []struct {
+		key    string
+		target **int64
+	}{{"min", &v.min}, {"max", &v.max}} {
+		if value, present := settings[bound.key]; present {
+			n, err := cast.ToInt64E(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", bound.key, err)
+			}
+			*bound.target = &n
+		}
+	}
+	if step, present := settings["step"]; present {
+		if v.step, err = cast.ToInt64E(step); err != nil {
+			return nil, fmt.Errorf("step: %s", err)
+		}
+	}
+	if value, present := settings["value"]; present {
+		if err := v.set(cast.ToString(value)); err != nil {
+			return nil, err
+		}
+	} else if v.value == nil {
+		v.value = int64(0)
+		if v.min != nil {
+			v.value = *v.min
+		}
+	}
+	return v, nil
+}
+
+func (v *variable) numeric() bool {
+	return v.values == nil
+}
+
+func (v *variable) set(value string) error {
+	if !v.numeric() {
+		v.value = value
+		return nil
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", value)
+	}
+	v.value = v.clamp(n)
+	return nil
+}
+
+func (v *variable) increment(step int64) error {
+	if !v.numeric() {
+		return fmt.Errorf("only numbers can be incremented")
+	}
+	v.value = v.clamp(v.value.(int64) + step)
+	return nil
+}
+
+// cycle moves on to the next value, numbers wrap around from max to min
+func (v *variable) cycle() error {
+	if !v.numeric() {
+		next := 0
+		for idx, value := range v.values {
+			if value == v.value {
+				next = (idx + 1) % len(v.values)
+			}
+		}
+		v.value = v.values[next]
+		return nil
+	}
+	if v.min == nil || v.max == nil {
+		return fmt.Errorf("numbers need min and max to be cycled")
+	}
+	n := v.value.(int64) + v.step
+	if n > *v.max {
+		n = *v.min
+	}
+	v.value = n
+	return nil
+}
+
+func (v *variable) clamp(n int64) int64 {
+	if v.min != nil && n < *v.min {
+		return *v.min
+	}
+	if v.max != nil && n > *v.max {
+		return *v.max
+	}
+	return n
+}
+
+// newVariables reads the variable declarations of a scene or the global ones
+func newVariables(scope string, decls map[string]interface{}) map[string]*variable {
+	vars := make(map[string]*variable)
+	for name, decl := range decls {
+		v, err := newVariable(decl)
+		if err != nil {
+			logger.Error("Invalid variable", "scope", scope, "name", name, "err", err)
+			continue
+		}
+		vars[name] = v
+	}
+	return vars
+}
+
+// values returns the current values by name
+func values(vars map[string]*variable) map[string]interface{} {
+	result := make(map[string]interface{}, len(vars))
+	for name, v := range vars {
+		result[name] = v.value
+	}
+	return result
+}
+
+// restore sets the values of the still declared variables, it ignores values which do not fit anymore
+func restore(vars map[string]*variable, saved map[string]interface{}) {
+	for name, value := range saved {
+		if v, present := vars[name]; present {
+			if err := v.set(cast.ToString(value)); err != nil {
+				logger.Warn("Unable to restore variable", "name", name, "err", err)
+			}
+		}
+	}
+}
+
+// update is a variable change done by an action, e.g. "increment volume 5"
+type update struct {
+	op   string
+	args string
+}
+
+// variable looks the variable up like the actions: in the scene, its parents, the global
+// and the default scene and finally among the global variables
+func (c *controller) variable(s *state, name string) (*variable, bool) {
+	for _, scope := range c.chain(s) {
+		if v, present := scope.vars[name]; present {
+			return v, true
+		}
+	}
+	v, present := c.globals[name]
+	return v, present
+}
+
+// apply runs the update against the variables visible in the scene, see variable.
+// The arguments are templates which are rendered with the event.
+func (c *controller) apply(s *state, u update, data TemplateData) error {
+	args, err := render(u.args, data)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return fmt.Errorf("%s needs a variable name", u.op)
+	}
+
+	v, present := c.variable(s, fields[0])
+	if !present {
+		return fmt.Errorf("unknown variable %s", fields[0])
+	}
+
+	switch u.op {
+	case "set":
+		return v.set(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(args), fields[0])))
+	case "increment":
+		step := v.step
+		if len(fields) > 1 {
+			if step, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+				return fmt.Errorf("%q is not a number", fields[1])
+			}
+		}
+		return v.increment(step)
+	case "cycle":
+		return v.cycle()
+	}
+	return fmt.Errorf("unknown operation %s", u.op)
+}
+
+// templateData is passed to the command templates: the event fields together with the
+// scene name and the variables visible in the scene, see variable
+func (c *controller) templateData(s *state, event nuimo.Event) TemplateData {
+	vars := values(c.globals)
+	chain := c.chain(s)
+	for idx := len(chain) - 1; idx >= 0; idx-- {
+		for name, value := range values(chain[idx].vars) {
+			vars[name] = value
+		}
+	}
+	return TemplateData{Event: event, Scene: s.Name, Vars: vars}
+}
@@ -0,0 +1,189 @@
+package scenes
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+)
+
+func TestNewVariable(t *testing.T) {
+	tests := []struct {
+		decl interface{}
+		want interface{}
+		err  bool
+	}{
+		{5, int64(5), false},
+		{"7", int64(7), false},
+		{[]interface{}{"HDMI1", "HDMI2"}, "HDMI1", false},
+		{map[string]interface{}{"min": 10, "max": 20}, int64(10), false},
+		{map[string]interface{}{"value": 50, "max": 20}, int64(20), false},
+		{map[string]interface{}{"values": []interface{}{"a", "b"}, "value": "b"}, "b", false},
+		{"on", nil, true},
+		{[]interface{}{}, nil, true},
+		{map[string]interface{}{"min": "low"}, nil, true},
+	}
+	for _, test := range tests {
+		v, err := newVariable(test.decl)
+		if test.err {
+			if err == nil {
+				t.Errorf("%v: expected an error, got %v", test.decl, v.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error %s", test.decl, err)
+			continue
+		}
+		if v.value != test.want {
+			t.Errorf("%v: expected %v (%T), got %v (%T)", test.decl, test.want, test.want, v.value, v.value)
+		}
+	}
+}
+
+func TestVariableOperations(t *testing.T) {
+	number, err := newVariable(map[string]interface{}{"value": 5, "min": 0, "max": 10, "step": 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := newVariable([]interface{}{"HDMI1", "HDMI2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name string
+		v    *variable
+		op   func(v *variable) error
+		want interface{}
+		err  bool
+	}{
+		{"increment", number, func(v *variable) error { return v.increment(v.step) }, int64(10), false},
+		{"increment above max", number, func(v *variable) error { return v.increment(3) }, int64(10), false},
+		{"cycle wraps to min", number, func(v *variable) error { return v.cycle() }, int64(0), false},
+		{"decrement below min", number, func(v *variable) error { return v.increment(-1) }, int64(0), false},
+		{"set", number, func(v *variable) error { return v.set(" 7 ") }, int64(7), false},
+		{"set clamps", number, func(v *variable) error { return v.set("70") }, int64(10), false},
+		{"set no number", number, func(v *variable) error { return v.set("loud") }, int64(10), true},
+		{"cycle", list, func(v *variable) error { return v.cycle() }, "HDMI2", false},
+		{"cycle wraps", list, func(v *variable) error { return v.cycle() }, "HDMI1", false},
+		{"set string", list, func(v *variable) error { return v.set("TV") }, "TV", false},
+		{"cycle from unknown value", list, func(v *variable) error { return v.cycle() }, "HDMI1", false},
+		{"increment string", list, func(v *variable) error { return v.increment(1) }, "HDMI1", true},
+	}
+	for _, step := range steps {
+		err := step.op(step.v)
+		if (err != nil) != step.err {
+			t.Errorf("%s: unexpected error %v", step.name, err)
+		}
+		if step.v.value != step.want {
+			t.Errorf("%s: expected %v, got %v", step.name, step.want, step.v.value)
+		}
+	}
+
+	unbounded, _ := newVariable(3)
+	if err := unbounded.cycle(); err == nil {
+		t.Error("expected cycling a number without min and max to fail")
+	}
+}
+
+const varsConfig = `
+vars:
+  volume:
+    value: 20
+    min: 0
+    max: 100
+    step: 5
+  level: 0
+scenes:
+  appletv:
+    vars:
+      input: [HDMI1, HDMI2, HDMI3]
+      level: 50
+    press:
+      cycle: input
+      do: "fhem: input {{.Vars.input}}"
+    rotate_right:
+      increment: volume
+      do: "fhem: volume {{.Vars.volume}} level {{.Vars.level}}"
+    rotate_left:
+      increment: volume -10
+      do: "fhem: volume {{.Vars.volume}}"
+    swipe_up:
+      set:
+        - level {{.Value}}
+        - volume 100
+      do: "fhem: level {{.Vars.level}} volume {{.Vars.volume}}"
+`
+
+func TestVariableActions(t *testing.T) {
+	c, r := newRecordingController(t, varsConfig)
+	for _, event := range []nuimo.Event{
+		{Key: "press"},
+		{Key: "release"},
+		{Key: "press"},
+		{Key: "release"},
+		{Key: "rotate", Value: 20},
+		{Key: "rotate", Value: -20},
+		{Key: "rotate", Value: -20},
+		{Key: "swipe_up", Value: 42},
+		{Key: "rotate", Value: 20},
+	} {
+		c.handleEvent(event)
+	}
+	want := "[input HDMI2 input HDMI3 volume 25 level 50 volume 15 volume 5 level 42 volume 100 volume 100 level 42]"
+	if got := handled(t, c, r); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	// the scene variable hides the global one of the same name
+	if level := values(c.globals)["level"]; level != int64(0) {
+		t.Errorf("expected the global level to stay 0, got %v", level)
+	}
+	if level := values(c.states[0].vars)["level"]; level != int64(42) {
+		t.Errorf("expected the scene level to be 42, got %v", level)
+	}
+}
+
+func TestVariablesPersisted(t *testing.T) {
+	path, cleanup := tempStateFile(t)
+	defer cleanup()
+
+	c := newTestController(t, varsConfig)
+	if err := c.PersistState(path); err != nil {
+		t.Fatal(err)
+	}
+	c.handleEvent(nuimo.Event{Key: "press"})
+	c.handleEvent(nuimo.Event{Key: "release"})
+	c.handleEvent(nuimo.Event{Key: "rotate", Value: 20})
+	c.Shutdown(time.Second)
+
+	restored := newTestController(t, varsConfig)
+	if err := restored.PersistState(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(values(restored.globals)); got != "map[level:0 volume:25]" {
+		t.Errorf("unexpected global variables %s", got)
+	}
+	if got := fmt.Sprint(values(restored.states[0].vars)); got != "map[input:HDMI2 level:50]" {
+		t.Errorf("unexpected scene variables %s", got)
+	}
+
+	// values which do not fit the declaration anymore are ignored
+	state := `{"scene": "appletv", "battery": -1, "globals": {"volume": "loud", "removed": 3}, "vars": {"appletv": {"level": 7}}}`
+	if err := ioutil.WriteFile(path, []byte(state), 0644); err != nil {
+		t.Fatal(err)
+	}
+	restored = newTestController(t, varsConfig)
+	if err := restored.PersistState(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(values(restored.globals)); got != "map[level:0 volume:20]" {
+		t.Errorf("unexpected global variables %s", got)
+	}
+	if got := fmt.Sprint(values(restored.states[0].vars)); got != "map[input:HDMI1 level:7]" {
+		t.Errorf("unexpected scene variables %s", got)
+	}
+}
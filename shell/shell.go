@@ -0,0 +1,175 @@
+// Package shell runs configured programs on the local machine, e.g. amixer or wake-on-LAN scripts.
+package shell
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mgutz/logxi/v1"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+	"github.com/tolleiv/nuimo-fhem/scenes"
+)
+
+var logger = log.New("shell")
+
+// Program is a configured command line. Only configured programs can be run by scenes.
+type Program struct {
+	Args    []string
+	Dir     string
+	Timeout time.Duration
+	// Env lists the names of the environment variables which are passed on to the program
+	Env []string
+	// Shell runs the command line with "/bin/sh -c", the arguments of the commands are quoted
+	// so that only the configured command line can use the shell
+	Shell bool
+}
+
+type Shell struct {
+	Programs    map[string]*Program
+	Concurrency int
+}
+
+// FromConfig creates the exec handle with the programs of the exec section of the configuration
+func FromConfig() (*Shell, error) {
+	s := &Shell{Programs: make(map[string]*Program), Concurrency: 1}
+	if viper.IsSet("exec.concurrency") {
+		s.Concurrency = viper.GetInt("exec.concurrency")
+	}
+	if s.Concurrency < 1 {
+		return nil, fmt.Errorf("Invalid concurrency %d", s.Concurrency)
+	}
+	timeout := 10 * time.Second
+	if viper.IsSet("exec.timeout") {
+		timeout = viper.GetDuration("exec.timeout")
+	}
+	env := []string{"PATH"}
+	if viper.IsSet("exec.env") {
+		env = viper.GetStringSlice("exec.env")
+	}
+
+	for name, value := range viper.GetStringMap("exec.commands") {
+		p := &Program{Timeout: timeout, Env: env}
+
+		// a program is either just the command line or a map with further settings
+		settings, err := cast.ToStringMapE(value)
+		if err != nil {
+			settings = map[string]interface{}{"command": value}
+		}
+		p.Args = cast.ToStringSlice(settings["command"])
+		p.Dir = cast.ToString(settings["dir"])
+		p.Shell = cast.ToBool(settings["shell"])
+		if t, present := settings["timeout"]; present {
+			p.Timeout = cast.ToDuration(t)
+		}
+		if e, present := settings["env"]; present {
+			p.Env = cast.ToStringSlice(e)
+		}
+
+		if len(p.Args) == 0 {
+			return nil, fmt.Errorf("Missing command for %s", name)
+		}
+		if p.Timeout <= 0 {
+			return nil, fmt.Errorf("Invalid timeout for %s", name)
+		}
+		logger.Debug("Program", name, strings.Join(p.Args, " "))
+		s.Programs[strings.ToLower(name)] = p
+	}
+	return s, nil
+}
+
+func init() {
+	scenes.Register("exec", func() (scenes.Handler, scenes.HandlerOptions, error) {
+		s, err := FromConfig()
+		if err != nil {
+			return nil, scenes.HandlerOptions{}, err
+		}
+		return s, scenes.HandlerOptions{Concurrency: s.Concurrency}, nil
+	})
+}
+
+// Handle runs a configured program. A command names the program followed by additional
+// arguments, e.g. "volume 5%+". Arguments are split at whitespace and passed on without
+// shell interpolation, also when the program is configured to run in a shell.
+func (s *Shell) Handle(ctx context.Context, cmd scenes.Command) (scenes.Result, error) {
+	logger.Debug("Trigger command", cmd.Text)
+	if err := s.run(ctx, cmd.Text); err != nil {
+		return scenes.Result{}, err
+	}
+	return scenes.Result{Output: "ok"}, nil
+}
+
+func (s *Shell) run(ctx context.Context, command string) error {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return fmt.Errorf("Empty command")
+	}
+	p, present := s.Programs[strings.ToLower(fields[0])]
+	if !present {
+		return fmt.Errorf("Unknown program %s", fields[0])
+	}
+
+	args := append(append([]string{}, p.Args...), fields[1:]...)
+	if p.Shell {
+		line := strings.Join(p.Args, " ")
+		for _, arg := range fields[1:] {
+			line += " " + quote(arg)
+		}
+		args = []string{"/bin/sh", "-c", line}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = p.Dir
+	cmd.Env = environment(p.Env)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	// the program gets its own process group, so that a timeout also stops its children
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("%s: %s", fields[0], err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		err = ctx.Err()
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %s: %s", fields[0], err, msg)
+		}
+		return fmt.Errorf("%s: %s", fields[0], err)
+	}
+	return nil
+}
+
+// quote makes the argument a single shell word
+func quote(arg string) string {
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
+
+// environment picks the allowed variables from the current environment
+func environment(allowed []string) []string {
+	env := []string{}
+	for _, name := range allowed {
+		if value, present := os.LookupEnv(name); present {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
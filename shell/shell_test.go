@@ -0,0 +1,214 @@
+package shell
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tolleiv/nuimo-fhem/nuimo"
+	"github.com/tolleiv/nuimo-fhem/scenes"
+)
+
+// tempDir creates a directory for the files written by the test programs
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "nuimo-shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func read(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func newShell(dir string, programs map[string]*Program) *Shell {
+	for _, p := range programs {
+		p.Dir = dir
+		if p.Timeout == 0 {
+			p.Timeout = time.Second
+		}
+		if p.Env == nil {
+			p.Env = []string{"PATH"}
+		}
+	}
+	return &Shell{Programs: programs, Concurrency: 1}
+}
+
+func TestUnknownProgram(t *testing.T) {
+	s := newShell("", map[string]*Program{"true": {Args: []string{"true"}}})
+	for _, text := range []string{"rm -rf /", "", "  "} {
+		if _, err := s.Handle(context.Background(), scenes.Command{Text: text}); err == nil {
+			t.Errorf("expected %q to be rejected", text)
+		}
+	}
+	if _, err := s.Handle(context.Background(), scenes.Command{Text: " TRUE"}); err != nil {
+		t.Errorf("expected the configured program to run, got %s", err)
+	}
+}
+
+func TestArgumentsWithoutShell(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	s := newShell(dir, map[string]*Program{
+		"args":   {Args: []string{"/bin/sh", "-c", `printf '%s|' "$@" > args`, "sh"}},
+		"quoted": {Args: []string{"printf '%s|' > args"}, Shell: true},
+	})
+
+	for _, program := range []string{"args", "quoted"} {
+		os.Remove(filepath.Join(dir, "args"))
+		if _, err := s.Handle(context.Background(), scenes.Command{Text: program + " $(touch pwned) ;touch 'pwned' `touch pwned`"}); err != nil {
+			t.Fatal(err)
+		}
+		if got := read(t, filepath.Join(dir, "args")); got != "$(touch|pwned)|;touch|'pwned'|`touch|pwned`|" {
+			t.Errorf("%s: unexpected arguments %s", program, got)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+			t.Fatalf("%s: the arguments were run by a shell", program)
+		}
+	}
+}
+
+func TestEnvironmentAndDir(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	os.Setenv("NUIMO_ALLOWED", "yes")
+	os.Setenv("NUIMO_SECRET", "no")
+	defer os.Unsetenv("NUIMO_ALLOWED")
+	defer os.Unsetenv("NUIMO_SECRET")
+
+	s := newShell(dir, map[string]*Program{
+		"env": {Args: []string{"/bin/sh", "-c", "env > env; pwd > pwd"}, Env: []string{"NUIMO_ALLOWED", "PATH"}},
+	})
+	if _, err := s.Handle(context.Background(), scenes.Command{Text: "env"}); err != nil {
+		t.Fatal(err)
+	}
+	env := read(t, filepath.Join(dir, "env"))
+	if !strings.Contains(env, "NUIMO_ALLOWED=yes") || strings.Contains(env, "NUIMO_SECRET") {
+		t.Errorf("expected only the allowed variables, got %s", env)
+	}
+	if pwd := strings.TrimSpace(read(t, filepath.Join(dir, "pwd"))); pwd != dir {
+		t.Errorf("expected the program to run in %s, got %s", dir, pwd)
+	}
+}
+
+func TestFailure(t *testing.T) {
+	s := newShell("", map[string]*Program{"fail": {Args: []string{"/bin/sh", "-c", "echo broken >&2; exit 3"}}})
+	_, err := s.Handle(context.Background(), scenes.Command{Text: "fail"})
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("expected the exit status and the error output, got %v", err)
+	}
+}
+
+func TestTimeoutKillsProcessGroup(t *testing.T) {
+	// the background sleep keeps the error output open, the program only returns early
+	// when the sleep is killed together with the shell
+	s := newShell("", map[string]*Program{
+		"slow": {Args: []string{"sleep 5 & wait"}, Shell: true, Timeout: 100 * time.Millisecond},
+	})
+	start := time.Now()
+	if _, err := s.Handle(context.Background(), scenes.Command{Text: "slow"}); err == nil {
+		t.Error("expected the timeout to be reported")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the program to be killed after the timeout, took %s", elapsed)
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	tests := []struct {
+		config string
+		err    bool
+	}{
+		{"exec:\n  commands:\n    volume: amixer set Master\n", false},
+		{"exec:\n  concurrency: 0\n  commands:\n    volume: amixer set Master\n", true},
+		{"exec:\n  commands:\n    volume:\n      dir: /tmp\n", true},
+		{"exec:\n  commands:\n    volume:\n      command: amixer\n      timeout: -1s\n", true},
+	}
+	for _, test := range tests {
+		viper.Reset()
+		viper.SetConfigType("yaml")
+		if err := viper.ReadConfig(strings.NewReader(test.config)); err != nil {
+			t.Fatal(err)
+		}
+		_, err := FromConfig()
+		if (err != nil) != test.err {
+			t.Errorf("unexpected error %v for %s", err, test.config)
+		}
+	}
+}
+
+// overlap returns the highest number of programs which ran at the same time according to the log
+func overlap(log string) int {
+	running, max := 0, 0
+	for _, line := range strings.Fields(log) {
+		if line == "start" {
+			running++
+		} else {
+			running--
+		}
+		if running > max {
+			max = running
+		}
+	}
+	return max
+}
+
+func TestConcurrency(t *testing.T) {
+	for _, concurrency := range []int{1, 2} {
+		dir, cleanup := tempDir(t)
+		defer cleanup()
+
+		viper.Reset()
+		viper.SetConfigType("yaml")
+		config := fmt.Sprintf(`
+exec:
+  concurrency: %d
+  commands:
+    slot:
+      command: [/bin/sh, -c, "echo start >> log; sleep 0.1; echo end >> log"]
+      dir: %s
+scenes:
+  shell:
+    press: "exec: slot"
+`, concurrency, dir)
+		if err := viper.ReadConfig(strings.NewReader(config)); err != nil {
+			t.Fatal(err)
+		}
+		c := scenes.NewController()
+		if err := c.HandleRegistered(); err != nil {
+			t.Fatal(err)
+		}
+		events := make(chan nuimo.Event)
+		ctx, cancel := context.WithCancel(context.Background())
+		go c.Listen(ctx, events)
+		for i := 0; i < 4; i++ {
+			events <- nuimo.Event{Key: "press"}
+		}
+		// once the next event is taken the last press was dispatched
+		events <- nuimo.Event{Key: "swipe_up"}
+		cancel()
+		if !c.Wait(5 * time.Second) {
+			t.Fatal("programs still running")
+		}
+		c.Shutdown(time.Second)
+
+		if got := overlap(read(t, filepath.Join(dir, "log"))); got != concurrency {
+			t.Errorf("expected %d programs at the same time, got %d", concurrency, got)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Package webhook sends scene commands as HTTP requests, e.g. to Kodi or Node-RED.
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mgutz/logxi/v1"
+	"github.com/spf13/viper"
+	"github.com/tolleiv/nuimo-fhem/scenes"
+)
+
+var logger = log.New("webhook")
+
+var methods = map[string]bool{"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true}
+
+// Endpoint is a named request target, so URLs and secrets are not repeated per action
+type Endpoint struct {
+	URL     string
+	Method  string
+	Headers map[string]string
+	Timeout time.Duration
+	// Success is matched against the response body, without it every 2xx response is a success
+	Success *regexp.Regexp
+}
+
+type Webhook struct {
+	Endpoints map[string]*Endpoint
+	Timeout   time.Duration
+	client    *http.Client
+}
+
+// FromConfig creates the webhook handle with the endpoints of the http section of the configuration
+func FromConfig() (*Webhook, error) {
+	w := &Webhook{Endpoints: make(map[string]*Endpoint), Timeout: 5 * time.Second, client: &http.Client{}}
+	if viper.IsSet("http.timeout") {
+		w.Timeout = viper.GetDuration("http.timeout")
+	}
+
+	for name := range viper.GetStringMap("http.endpoints") {
+		key := "http.endpoints." + name
+		e := &Endpoint{
+			URL:     viper.GetString(key + ".url"),
+			Method:  strings.ToUpper(viper.GetString(key + ".method")),
+			Headers: viper.GetStringMapString(key + ".headers"),
+			Timeout: w.Timeout,
+		}
+		if methods[strings.ToUpper(name)] {
+			return nil, fmt.Errorf("Endpoint %s is named like a method", name)
+		}
+		if e.URL == "" {
+			return nil, fmt.Errorf("Missing url for endpoint %s", name)
+		}
+		if e.Method == "" {
+			e.Method = "POST"
+		}
+		if !methods[e.Method] {
+			return nil, fmt.Errorf("Unsupported method %s for endpoint %s", e.Method, name)
+		}
+		if viper.IsSet(key + ".timeout") {
+			e.Timeout = viper.GetDuration(key + ".timeout")
+		}
+		if success := viper.GetString(key + ".success"); success != "" {
+			re, err := regexp.Compile(success)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid success pattern for endpoint %s: %s", name, err)
+			}
+			e.Success = re
+		}
+		logger.Debug("Endpoint", name, e.URL)
+		w.Endpoints[strings.ToLower(name)] = e
+	}
+	return w, nil
+}
+
+func init() {
+	scenes.Register("http", func() (scenes.Handler, scenes.HandlerOptions, error) {
+		w, err := FromConfig()
+		if err != nil {
+			return nil, scenes.HandlerOptions{}, err
+		}
+		return w, scenes.HandlerOptions{Concurrency: viper.GetInt("http.concurrency")}, nil
+	})
+}
+
+// Handle sends a request. A command either names a configured endpoint followed by the
+// request body, e.g. "kodi {\"method\":\"Player.PlayPause\"}", or consists of method, URL
+// and optional body, e.g. "GET http://kodi.local:8080/jsonrpc".
+func (w *Webhook) Handle(ctx context.Context, cmd scenes.Command) (scenes.Result, error) {
+	logger.Debug("Trigger command", cmd.Text)
+	if err := w.request(ctx, cmd.Text); err != nil {
+		return scenes.Result{}, err
+	}
+	return scenes.Result{Output: "ok"}, nil
+}
+
+func (w *Webhook) request(ctx context.Context, command string) error {
+	e, body, err := w.endpoint(command)
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(e.Method, e.URL, reader)
+	if err != nil {
+		return err
+	}
+	for name, value := range e.Headers {
+		req.Header.Set(name, value)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.Timeout)
+	defer cancel()
+
+	resp, err := w.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Unexpected status %s", resp.Status)
+	}
+	if e.Success != nil && !e.Success.Match(respBody) {
+		return fmt.Errorf("Response does not match %s", e.Success)
+	}
+	return nil
+}
+
+func (w *Webhook) endpoint(command string) (*Endpoint, string, error) {
+	parts := strings.SplitN(strings.TrimSpace(command), " ", 2)
+	body := ""
+	if len(parts) == 2 {
+		body = strings.TrimSpace(parts[1])
+	}
+
+	if methods[parts[0]] {
+		if body == "" {
+			return nil, "", fmt.Errorf("Missing URL in command %s", command)
+		}
+		target := strings.SplitN(body, " ", 2)
+		e := &Endpoint{URL: target[0], Method: parts[0], Timeout: w.Timeout}
+		body = ""
+		if len(target) == 2 {
+			body = strings.TrimSpace(target[1])
+		}
+		return e, body, nil
+	}
+
+	e, present := w.Endpoints[strings.ToLower(parts[0])]
+	if !present {
+		return nil, "", fmt.Errorf("Unknown endpoint %s", parts[0])
+	}
+	return e, body, nil
+}
@@ -0,0 +1,180 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tolleiv/nuimo-fhem/scenes"
+)
+
+// request is what the test server received
+type request struct {
+	method string
+	path   string
+	body   string
+	header http.Header
+}
+
+// server answers every request with the given status and body after the delay
+func server(status int, body string, delay time.Duration) (*httptest.Server, chan request) {
+	requests := make(chan request, 8)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		requests <- request{method: r.Method, path: r.URL.Path, body: string(data), header: r.Header}
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	return s, requests
+}
+
+func newWebhook(endpoints map[string]*Endpoint) *Webhook {
+	return &Webhook{Endpoints: endpoints, Timeout: time.Second, client: &http.Client{}}
+}
+
+func handle(w *Webhook, text string) error {
+	_, err := w.Handle(context.Background(), scenes.Command{Handle: "http", Text: text})
+	return err
+}
+
+func TestEndpoint(t *testing.T) {
+	s, requests := server(http.StatusOK, `{"result":"OK"}`, 0)
+	defer s.Close()
+	w := newWebhook(map[string]*Endpoint{
+		"kodi": {
+			URL:     s.URL + "/jsonrpc",
+			Method:  "POST",
+			Headers: map[string]string{"Authorization": "Basic secret"},
+			Timeout: time.Second,
+			Success: regexp.MustCompile(`"result":"OK"`),
+		},
+	})
+
+	if err := handle(w, ` Kodi {"method": "Player.PlayPause"} `); err != nil {
+		t.Fatal(err)
+	}
+	r := <-requests
+	if r.method != "POST" || r.path != "/jsonrpc" || r.body != `{"method": "Player.PlayPause"}` {
+		t.Errorf("unexpected request %s %s %s", r.method, r.path, r.body)
+	}
+	if r.header.Get("Authorization") != "Basic secret" {
+		t.Errorf("expected the configured header, got %v", r.header)
+	}
+
+	if err := handle(w, "unknown {}"); err == nil || !strings.Contains(err.Error(), "Unknown endpoint") {
+		t.Errorf("expected an unknown endpoint to fail, got %v", err)
+	}
+}
+
+func TestInlineRequest(t *testing.T) {
+	s, requests := server(http.StatusOK, "", 0)
+	defer s.Close()
+	w := newWebhook(map[string]*Endpoint{})
+
+	tests := []struct {
+		text   string
+		method string
+		path   string
+		body   string
+	}{
+		{"GET " + s.URL + "/light/on", "GET", "/light/on", ""},
+		{"PUT " + s.URL + "/light  {\"state\": \"ON\"}", "PUT", "/light", `{"state": "ON"}`},
+		{"DELETE " + s.URL + "/timer", "DELETE", "/timer", ""},
+	}
+	for _, test := range tests {
+		if err := handle(w, test.text); err != nil {
+			t.Fatalf("%s: %s", test.text, err)
+		}
+		r := <-requests
+		if r.method != test.method || r.path != test.path || r.body != test.body {
+			t.Errorf("%s: unexpected request %s %s %q", test.text, r.method, r.path, r.body)
+		}
+	}
+
+	if err := handle(w, "GET"); err == nil {
+		t.Error("expected a request without URL to fail")
+	}
+}
+
+func TestResponse(t *testing.T) {
+	tests := []struct {
+		status  int
+		body    string
+		success string
+		err     bool
+	}{
+		{http.StatusOK, "anything", "", false},
+		{http.StatusNoContent, "", "", false},
+		{http.StatusInternalServerError, "", "", true},
+		{http.StatusNotFound, `"result":"OK"`, `"result":"OK"`, true},
+		{http.StatusOK, `{"result":"OK"}`, `"result":"OK"`, false},
+		{http.StatusOK, `{"error":"busy"}`, `"result":"OK"`, true},
+	}
+	for _, test := range tests {
+		s, requests := server(test.status, test.body, 0)
+		e := &Endpoint{URL: s.URL, Method: "POST", Timeout: time.Second}
+		if test.success != "" {
+			e.Success = regexp.MustCompile(test.success)
+		}
+		err := handle(newWebhook(map[string]*Endpoint{"svc": e}), "svc")
+		if (err != nil) != test.err {
+			t.Errorf("%d %s: unexpected error %v", test.status, test.body, err)
+		}
+		<-requests
+		s.Close()
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	s, requests := server(http.StatusOK, "", time.Second)
+	defer s.Close()
+	w := newWebhook(map[string]*Endpoint{"slow": {URL: s.URL, Method: "POST", Timeout: 50 * time.Millisecond}})
+
+	start := time.Now()
+	if err := handle(w, "slow"); err == nil {
+		t.Error("expected the request to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the request to be aborted after the timeout, took %s", elapsed)
+	}
+	<-requests
+}
+
+func TestFromConfig(t *testing.T) {
+	tests := []struct {
+		config string
+		err    bool
+	}{
+		{"http:\n  endpoints:\n    kodi:\n      url: http://kodi.local\n", false},
+		{"http:\n  endpoints:\n    kodi:\n      method: GET\n", true},
+		{"http:\n  endpoints:\n    kodi:\n      url: http://kodi.local\n      method: CONNECT\n", true},
+		{"http:\n  endpoints:\n    kodi:\n      url: http://kodi.local\n      success: '('\n", true},
+		{"http:\n  endpoints:\n    get:\n      url: http://kodi.local\n", true},
+		{"http:\n  endpoints:\n    Post:\n      url: http://kodi.local\n", true},
+	}
+	for _, test := range tests {
+		viper.Reset()
+		viper.SetConfigType("yaml")
+		if err := viper.ReadConfig(strings.NewReader(test.config)); err != nil {
+			t.Fatal(err)
+		}
+		w, err := FromConfig()
+		if (err != nil) != test.err {
+			t.Errorf("unexpected error %v for %s", err, test.config)
+		}
+		if err == nil && w.Endpoints["kodi"].Method != "POST" {
+			t.Errorf("expected POST as default method, got %s", w.Endpoints["kodi"].Method)
+		}
+	}
+}